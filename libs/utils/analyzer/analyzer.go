@@ -0,0 +1,141 @@
+// Package analyzer turns text into the terms that are indexed and searched
+// for. The crawler analyzes a document with the analyzer of its language and
+// the query engine a query with the analyzer of the language it asks for,
+// Default when it asks for none, so a term found at index time is the term a
+// query for the same words looks up.
+package analyzer
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/cases"
+)
+
+// Filter is a stage of an Analyzer, it returns the token to pass on
+// to the next stage or an empty string to drop it
+type Filter func(token string) string
+
+// Analyzer splits text into words and runs every word through its filters in order
+type Analyzer struct {
+	filters []Filter
+}
+
+func New(filters ...Filter) *Analyzer {
+	return &Analyzer{filters: filters}
+}
+
+// Default analyzes english, the language of documents whose language is unknown
+var Default = For(English)
+
+// Analyze returns the terms of text in the order they appear
+func (a *Analyzer) Analyze(text string) []string {
+	terms := []string{}
+	for _, word := range Words(text) {
+		if term := a.filter(word); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func (a *Analyzer) filter(token string) string {
+	for _, filter := range a.filters {
+		if token = filter(token); token == "" {
+			return ""
+		}
+	}
+	return token
+}
+
+// Words splits text at the unicode word boundaries, leaving out the
+// spaces and punctuation between words
+func Words(text string) []string {
+	words := []string{}
+	state := -1
+	for len(text) > 0 {
+		var word string
+		word, text, state = uniseg.FirstWordInString(text, state)
+		if strings.IndexFunc(word, isWordRune) >= 0 {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+var folder = cases.Fold()
+
+// Fold folds case so the forms of a word in any case compare equal
+func Fold(token string) string {
+	return folder.String(token)
+}
+
+// StripPunctuation removes everything but letters and digits,
+// "osu!" and "don't" become "osu" and "dont"
+func StripPunctuation(token string) string {
+	return strings.Map(func(r rune) rune {
+		if isWordRune(r) {
+			return r
+		}
+		return -1
+	}, token)
+}
+
+// StopWords drops the words in list, they have to be folded
+func StopWords(list []string) Filter {
+	stop := make(map[string]bool, len(list))
+	for _, word := range list {
+		stop[word] = true
+	}
+	return func(token string) string {
+		if stop[token] {
+			return ""
+		}
+		return token
+	}
+}
+
+// StopWordFunc drops the words isStop reports, they are passed folded
+func StopWordFunc(isStop func(word string) bool) Filter {
+	return func(token string) string {
+		if isStop(token) {
+			return ""
+		}
+		return token
+	}
+}
+
+// Stemmer reduces tokens to their stem with a snowball stem function
+func Stemmer(stem func(word string, stemStopWords bool) string) Filter {
+	return func(token string) string {
+		return stem(token, true)
+	}
+}
+
+// Length drops tokens shorter than min or longer than max characters.
+// Chinese and japanese are split into single characters which are words
+// on their own, they are never too short.
+func Length(min int, max int) Filter {
+	return func(token string) string {
+		n := utf8.RuneCountInString(token)
+		if n < min && !isIdeographic(token) || n > max {
+			return ""
+		}
+		return token
+	}
+}
+
+func isIdeographic(token string) bool {
+	for _, r := range token {
+		if !unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			return false
+		}
+	}
+	return token != ""
+}
@@ -0,0 +1,83 @@
+package analyzer
+
+import (
+	"reflect"
+	"testing"
+	"utils/analyzer/analyzertest"
+)
+
+func TestGolden(t *testing.T) {
+	cases, err := analyzertest.ReadGolden(analyzertest.GoldenFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		if terms := Default.Analyze(c.Input); !reflect.DeepEqual(terms, c.Terms) {
+			t.Errorf("%q: expected %v got %v", c.Input, c.Terms, terms)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	words := Words("Hello, world! (osu!mania) 4.5 stars")
+	expected := []string{"Hello", "world", "osu", "mania", "4.5", "stars"}
+	if !reflect.DeepEqual(words, expected) {
+		t.Errorf("expected %v got %v", expected, words)
+	}
+}
+
+func TestCustomAnalyzer(t *testing.T) {
+	a := New(Fold, StopWords([]string{"the"}), Length(3, 5))
+	if terms := a.Analyze("The Longest Words win"); !reflect.DeepEqual(terms, []string{"words", "win"}) {
+		t.Errorf("unexpected terms %v", terms)
+	}
+}
+
+func TestLanguageAnalyzers(t *testing.T) {
+	cases := []struct {
+		lang     string
+		text     string
+		expected []string
+	}{
+		{"fr", "Les joueurs jouaient aux cartes", []string{"le", "joueur", "jou", "cart"}},
+		{"es-ES", "Los jugadores jugaban", []string{"jugador", "jug"}},
+		{"nb", "Spillerne spilte", []string{"spillern", "spilt"}},
+		//no stemmer, only folded
+		{"de", "Die Spieler spielten", []string{"die", "spieler", "spielten"}},
+		//written without spaces, every character is a term
+		{"zh", "我爱北京", []string{"我", "爱", "北", "京"}},
+		{"ja", "ゲームをプレイ", []string{"ゲーム", "を", "プレイ"}},
+		{"", "The players played", []string{"player", "play"}},
+	}
+
+	for _, c := range cases {
+		if terms := For(c.lang).Analyze(c.text); !reflect.DeepEqual(terms, c.expected) {
+			t.Errorf("%v %q: expected %v got %v", c.lang, c.text, c.expected, terms)
+		}
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	french := "Le chat est assis sur le tapis et regarde les oiseaux qui chantent dans le jardin."
+	english := "The quick brown fox jumps over the lazy dog while the farmer watches from the window of his house."
+
+	cases := []struct {
+		declared string
+		text     string
+		expected string
+	}{
+		{"", french, "fr"},
+		{"", english, "en"},
+		{"fr-CA", english, "fr"},
+		{"nn", "", "no"},
+		{"", "osu", ""},
+		{"", "", ""},
+	}
+
+	for _, c := range cases {
+		if lang := DetectLanguage(c.declared, c.text); lang != c.expected {
+			t.Errorf("%q %q: expected %q got %q", c.declared, c.text, c.expected, lang)
+		}
+	}
+}
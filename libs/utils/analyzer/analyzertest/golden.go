@@ -0,0 +1,53 @@
+// Package analyzertest has the golden file every path that analyzes text is
+// tested against. Only tests import it, the file is read from the source tree.
+package analyzertest
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// GoldenFile returns the path of the golden file for analyzer.Default
+func GoldenFile() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "testdata", "golden.txt")
+}
+
+// GoldenCase is a line of a golden file, the terms analyzer.Default has to produce for Input
+type GoldenCase struct {
+	Input string
+	Terms []string
+}
+
+// ReadGolden reads a golden file of "input<TAB>terms" lines, lines starting
+// with # are comments. Every path that analyzes text tests against the same file.
+func ReadGolden(path string) ([]GoldenCase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open golden file %v %v", path, err)
+	}
+	defer f.Close()
+
+	cases := []GoldenCase{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		input, terms, ok := strings.Cut(line, "\t")
+		if !ok {
+			return nil, fmt.Errorf("golden line without a tab %q", line)
+		}
+		cases = append(cases, GoldenCase{Input: input, Terms: strings.Fields(terms)})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read golden file %v %v", path, err)
+	}
+
+	return cases, nil
+}
@@ -0,0 +1,89 @@
+package analyzer
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/abadojack/whatlanggo"
+	"github.com/kljensen/snowball/english"
+	"github.com/kljensen/snowball/french"
+	"github.com/kljensen/snowball/hungarian"
+	"github.com/kljensen/snowball/norwegian"
+	"github.com/kljensen/snowball/russian"
+	"github.com/kljensen/snowball/spanish"
+	"github.com/kljensen/snowball/swedish"
+)
+
+// languages are ISO 639-1 codes
+const English = "en"
+
+// how much of a document the classifier looks at, enough to be sure and
+// bounded so long documents don't cost more
+const detectBytes = 4096
+
+// the languages there is a snowball stemmer and stop list for
+var analyzers = map[string]*Analyzer{
+	"en": snowball(english.IsStopWord, english.Stem),
+	"fr": snowball(french.IsStopWord, french.Stem),
+	"es": snowball(spanish.IsStopWord, spanish.Stem),
+	"ru": snowball(russian.IsStopWord, russian.Stem),
+	"sv": snowball(swedish.IsStopWord, swedish.Stem),
+	"no": snowball(norwegian.IsStopWord, norwegian.Stem),
+	"hu": snowball(hungarian.IsStopWord, hungarian.Stem),
+}
+
+// Neutral analyzes the languages without a stemmer, it only folds and strips
+var Neutral = New(Fold, StripPunctuation, Length(2, 32))
+
+func snowball(isStop func(string) bool, stem func(string, bool) string) *Analyzer {
+	return New(Fold, StripPunctuation, StopWordFunc(isStop), Stemmer(stem), Length(2, 32))
+}
+
+// For returns the analyzer of lang, Default when the language is unknown
+// and Neutral when there is no stemmer for it
+func For(lang string) *Analyzer {
+	lang = NormalizeLanguage(lang)
+	if lang == "" {
+		return analyzers[English]
+	}
+	if a, ok := analyzers[lang]; ok {
+		return a
+	}
+	return Neutral
+}
+
+// NormalizeLanguage turns a language tag like "en-US" into the code of its
+// language, the norwegian written standards share one stemmer
+func NormalizeLanguage(tag string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	lang, _, _ = strings.Cut(lang, "_")
+	switch {
+	case lang == "nb" || lang == "nn":
+		return "no"
+	case len(lang) != 2:
+		return ""
+	}
+	return lang
+}
+
+// DetectLanguage returns the language of a document, the one it declares
+// when there is one or else what the classifier makes of text.
+// It returns an empty string when neither is sure.
+func DetectLanguage(declared string, text string) string {
+	if lang := NormalizeLanguage(declared); lang != "" {
+		return lang
+	}
+
+	if len(text) > detectBytes {
+		text = text[:detectBytes]
+		//don't leave half a rune at the end
+		for !utf8.ValidString(text) {
+			text = text[:len(text)-1]
+		}
+	}
+	info := whatlanggo.Detect(text)
+	if !info.IsReliable() {
+		return ""
+	}
+	return NormalizeLanguage(info.Lang.Iso6391())
+}
@@ -2,4 +2,9 @@ module utils
 
 go 1.25.1
 
-require github.com/reiver/go-porterstemmer v1.0.1
+require (
+	github.com/abadojack/whatlanggo v1.0.1
+	github.com/kljensen/snowball v0.10.0
+	github.com/rivo/uniseg v0.4.7
+	golang.org/x/text v0.29.0
+)
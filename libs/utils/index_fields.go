@@ -0,0 +1,15 @@
+package utils
+
+// fields the terms of a document are indexed under, ranking can weight them separately
+const (
+	FieldTitle   = "title"
+	FieldHeading = "heading"
+	FieldBody    = "body"
+	FieldUrl     = "url"
+)
+
+var IndexFields = []string{FieldTitle, FieldHeading, FieldBody, FieldUrl}
+
+// text of the links pointing to a document, it is stored apart from the
+// fields above since other pages write it
+const FieldAnchor = "anchor"
@@ -0,0 +1,6 @@
+package utils
+
+// IndexVersionKey holds the prefix every key of the live index is kept
+// under, a reindex swaps in a new index by changing it. The index from
+// before versions has no prefix and no IndexVersionKey.
+const IndexVersionKey = "indexversion"
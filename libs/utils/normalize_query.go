@@ -1,55 +0,0 @@
-package utils
-
-import (
-	"slices"
-	"strings"
-	"unicode"
-
-	"github.com/reiver/go-porterstemmer"
-)
-
-var stopWords = []string{"i", "me", "my", "myself", "we", "our", "ours", "ourselves", "you", "your", "yours", "yourself", "yourselves", "he", "him", "his", "himself", "she", "her", "hers", "herself", "it", "its", "itself", "they", "them", "their", "theirs", "themselves", "what", "which", "who", "whom", "this", "that", "these", "those", "am", "is", "are", "was", "were", "be", "been", "being", "have", "has", "had", "having", "do", "does", "did", "doing", "a", "an", "the", "and", "but", "if", "or", "because", "as", "until", "while", "of", "at", "by", "for", "with", "about", "against", "between", "into", "through", "during", "before", "after", "above", "below", "to", "from", "up", "down", "in", "out", "on", "off", "over", "under", "again", "further", "then", "once", "here", "there", "when", "where", "why", "how", "all", "any", "both", "each", "few", "more", "most", "other", "some", "such", "no", "nor", "not", "only", "own", "same", "so", "than", "too", "very", "s", "t", "can", "will", "just", "don", "should", "now"}
-
-func NormalizeQuery(query string) []string {
-	words := make([]string, 0)
-	for word := range strings.SplitSeq(query, " ") {
-		word = strings.ToLower(word)
-		word = strings.TrimSpace(word)
-		word = RemovePunctuation(word)
-		stem := porterstemmer.StemWithoutLowerCasing([]rune(word))
-
-		if len(stem) >= 2 &&
-			len(stem) <= 32 &&
-			!slices.Contains(stopWords, word) &&
-			IsAlphanumeric(string(stem)) {
-			words = append(words, string(stem))
-		}
-	}
-
-	return words
-}
-
-func RemovePunctuation(s string) string {
-	replacer := strings.NewReplacer(
-		",", "",
-		".", "",
-		";", "",
-		":", "",
-		"!", "",
-		"?", "",
-	)
-
-	return replacer.Replace(s)
-}
-
-func IsAlphanumeric(s string) bool {
-	if s == "" {
-		return false
-	}
-	for _, r := range s {
-		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
-			return false
-		}
-	}
-	return true
-}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EncodePositions packs ascending term positions into the gaps between
+// them as uvarints, most gaps fit in a single byte
+func EncodePositions(positions []int) string {
+	buf := make([]byte, 0, len(positions))
+	last := 0
+	for _, position := range positions {
+		buf = binary.AppendUvarint(buf, uint64(position-last))
+		last = position
+	}
+	return string(buf)
+}
+
+func DecodePositions(encoded string) ([]int, error) {
+	buf := []byte(encoded)
+	positions := make([]int, 0, len(buf))
+	last := 0
+	for len(buf) > 0 {
+		gap, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid encoded positions at byte %d", len(encoded)-len(buf))
+		}
+		last += int(gap)
+		positions = append(positions, last)
+		buf = buf[n:]
+	}
+	return positions, nil
+}
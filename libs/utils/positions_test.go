@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPositions(t *testing.T) {
+	positions := []int{0, 3, 4, 200, 70000}
+
+	encoded := EncodePositions(positions)
+	//gaps under 128 take one byte
+	if len(encoded) != 1+1+1+2+3 {
+		t.Errorf("expected 8 bytes got %d", len(encoded))
+	}
+
+	decoded, err := DecodePositions(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, positions) {
+		t.Errorf("expected %v got %v", positions, decoded)
+	}
+
+	if _, err = DecodePositions("\x80"); err == nil {
+		t.Error("expected an error for a truncated uvarint")
+	}
+}
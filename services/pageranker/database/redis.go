@@ -6,6 +6,7 @@ import (
 	"indexer/types"
 	"strconv"
 	"strings"
+	"utils"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -13,6 +14,8 @@ import (
 type DataBase struct {
 	client *redis.Client
 	ctx    context.Context
+	//the live index the crawler wrote is kept under it, see utils.IndexVersionKey
+	indexPrefix string
 }
 
 func (db *DataBase) Connect(addr string, database string, password string) error {
@@ -34,11 +37,17 @@ func (db *DataBase) Connect(addr string, database string, password string) error
 		return fmt.Errorf("couldn't connect do db %v %v", addr, err)
 	}
 
+	//a run ranks the link graph that was live when it started
+	db.indexPrefix, err = db.client.Get(db.ctx, utils.IndexVersionKey).Result()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("could not get %v %v", utils.IndexVersionKey, err)
+	}
+
 	return nil
 }
 
 func (db *DataBase) GetPageNodes() ([]types.PageNode, error) {
-	keys, err := db.client.LRange(db.ctx, "outlinks:index", 1, -1).Result()
+	keys, err := db.client.LRange(db.ctx, db.indexPrefix+"outlinks:index", 1, -1).Result()
 	if err != nil {
 		return nil, fmt.Errorf("error for fetching outlinks in range %d-%d %v", 0, -1, err)
 	}
@@ -46,14 +55,15 @@ func (db *DataBase) GetPageNodes() ([]types.PageNode, error) {
 	pageNodes := make([]types.PageNode, len(keys))
 	//fetch associated links
 	for i, key := range keys {
-		outLinks, err := db.client.SMembers(db.ctx, key).Result()
+		//the list names the keys without the prefix
+		outLinks, err := db.client.SMembers(db.ctx, db.indexPrefix+key).Result()
 		if err != nil {
 			return nil, fmt.Errorf("error fetching key %v reason %v", key, err)
 		}
 
 		urlHash := strings.Split(key, ":")[1]
 
-		backlinksKey := "backlinks:" + urlHash
+		backlinksKey := db.indexPrefix + "backlinks:" + urlHash
 
 		backLinks, err := db.client.SMembers(db.ctx, backlinksKey).Result()
 		if err != nil {
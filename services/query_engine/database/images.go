@@ -6,7 +6,7 @@ import (
 )
 
 func (db *DataBase) GetImageTopX(word string, x int64) (types.ImageIndex, error) {
-	r, err := db.client.ZRevRangeWithScores(db.ctx, "imageindex:"+word, 0, x-1).Result()
+	r, err := db.client.ZRevRangeWithScores(db.ctx, db.indexKey("imageindex:"+word), 0, x-1).Result()
 	if err != nil {
 		return types.ImageIndex{}, fmt.Errorf("could not get image %v from db %v", word, err)
 	}
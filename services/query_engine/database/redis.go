@@ -3,9 +3,11 @@ package database
 import (
 	"context"
 	"fmt"
+	"log"
 	"math"
 	"query_engine/types"
 	"strconv"
+	"sync"
 	"time"
 	"utils"
 
@@ -15,8 +17,17 @@ import (
 type DataBase struct {
 	client *redis.Client
 	ctx    context.Context
+
+	//prefix of the live index, read from utils.IndexVersionKey at most every indexPrefixTTL
+	mu              sync.Mutex
+	indexPrefix     string
+	indexPrefixRead time.Time
 }
 
+// how long the prefix of the live index is used before it is read again,
+// queries find a swapped in index within it
+const indexPrefixTTL = time.Second
+
 func (db *DataBase) Connect(addr string, database string, password string) error {
 	dbId, err := strconv.Atoi(database)
 	if err != nil {
@@ -39,6 +50,24 @@ func (db *DataBase) Connect(addr string, database string, password string) error
 	return nil
 }
 
+// indexKey returns the key of the live index the crawler wrote key under,
+// the scores tfidf computed from it are kept outside of it
+func (db *DataBase) indexKey(key string) string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if time.Since(db.indexPrefixRead) >= indexPrefixTTL {
+		prefix, err := db.client.Get(db.ctx, utils.IndexVersionKey).Result()
+		if err != nil && err != redis.Nil {
+			log.Printf("could not read %v %v", utils.IndexVersionKey, err)
+		} else {
+			db.indexPrefix = prefix
+			db.indexPrefixRead = time.Now()
+		}
+	}
+	return db.indexPrefix + key
+}
+
 func (db *DataBase) getIndex(prefix, word string) (types.Index, error) {
 	r, err := db.client.ZRevRangeWithScores(db.ctx, prefix+word, 0, -1).Result()
 	if err != nil {
@@ -104,7 +133,7 @@ func (db *DataBase) GetDocumentMagnitude(link string) (float64, error) {
 }
 
 func (db *DataBase) GetDocument(normUrl string) (types.Document, error) {
-	r, err := db.client.HGetAll(db.ctx, "document:"+utils.HashUrl(normUrl)).Result()
+	r, err := db.client.HGetAll(db.ctx, db.indexKey("document:"+utils.HashUrl(normUrl))).Result()
 	if err != nil {
 		return types.Document{}, fmt.Errorf("could not get document %v from db %v", normUrl, err)
 	}
@@ -119,13 +148,14 @@ func (db *DataBase) GetDocument(normUrl string) (types.Document, error) {
 		NormUrl: normUrl,
 		Length:  length,
 		Title:   r["title"],
+		Lang:    r["lang"],
 	}
 
 	return d, nil
 }
 
 func (db *DataBase) GetDocsCount() (int64, error) {
-	r, err := db.client.Get(db.ctx, "domain:count").Result()
+	r, err := db.client.Get(db.ctx, db.indexKey("domain:count")).Result()
 	if err != nil {
 		return 0, fmt.Errorf("could not get domain count from db %v", err)
 	}
@@ -139,7 +169,7 @@ func (db *DataBase) GetDocsCount() (int64, error) {
 }
 
 func (db *DataBase) GetDocLength(normUrl string) (int64, error) {
-	key := "document:" + utils.HashUrl(normUrl)
+	key := db.indexKey("document:" + utils.HashUrl(normUrl))
 	r, err := db.client.HGet(db.ctx, key, "length").Result()
 	if err != nil {
 		return 0, fmt.Errorf("could not get length of document %v from db %v", normUrl, err)
@@ -231,15 +261,18 @@ func (db *DataBase) ComputeCosineSimilarity(words []string, linkScores map[strin
 	return cosineScores, nil
 }
 
-func (db *DataBase) GetCandidateLinks(words []string, limit int64) (map[string]float64, error) {
+// GetCandidateLinks returns up to limit links that have any of the words, scored by their summed TF-IDF.
+// Only links in lang are returned unless lang is empty.
+func (db *DataBase) GetCandidateLinks(words []string, limit int64, lang string) (map[string]float64, error) {
 	if len(words) == 0 {
 		return make(map[string]float64), nil
 	}
 
 	// 1. Prepare keys for ZUNIONSTORE
-	tfidfKeys := make([]string, len(words))
-	for i, word := range words {
-		tfidfKeys[i] = fmt.Sprintf("tfidf:%s", word)
+	// Anchor text postings are included so pages that were linked but not crawled can be found.
+	tfidfKeys := make([]string, 0, 2*len(words))
+	for _, word := range words {
+		tfidfKeys = append(tfidfKeys, fmt.Sprintf("tfidf:%s", word), anchorKey(word))
 	}
 
 	// 2. Define a temporary key for the aggregated results
@@ -260,6 +293,10 @@ func (db *DataBase) GetCandidateLinks(words []string, limit int64) (map[string]f
 	// 4. Cleanup: Ensure the temporary key is deleted when done (best practice)
 	defer db.client.Del(db.ctx, tempKey)
 
+	if err = db.restrictLanguage(tempKey, lang); err != nil {
+		return nil, err
+	}
+
 	// 5. Fetch the top candidates from the temporary result
 	// We use the limit here to keep the candidate set small before the expensive cosine calculation.
 	results, err := db.client.ZRevRangeWithScores(db.ctx, tempKey, 0, limit-1).Result()
@@ -278,4 +315,129 @@ func (db *DataBase) GetCandidateLinks(words []string, limit int64) (map[string]f
 	}
 
 	return linkScores, nil
-}
\ No newline at end of file
+}
+
+// restrictLanguage drops the links that aren't in lang from the sorted set at key, keeping their scores
+func (db *DataBase) restrictLanguage(key string, lang string) error {
+	if lang == "" {
+		return nil
+	}
+
+	// Members of a plain set score 1, weighted 0 they leave the sums alone
+	err := db.client.ZInterStore(db.ctx, key, &redis.ZStore{
+		Keys:    []string{key, db.indexKey("lang:" + lang)},
+		Weights: []float64{1, 0},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("redis ZINTERSTORE with language %s failed: %w", lang, err)
+	}
+	return nil
+}
+
+func anchorKey(word string) string {
+	return "fieldtfidf:" + utils.FieldAnchor + ":" + word
+}
+
+// GetAnchorScores scores how well the anchor text of the links pointing at each link matches the words.
+// Every word's TF-IDF is divided by its IDF at most, so the score is between 0 and 1.
+func (db *DataBase) GetAnchorScores(words []string, linkScores map[string]float64) (map[string]float64, error) {
+	var idfSum float64
+	for _, word := range words {
+		idf, err := db.GetIdf(word)
+		if err != nil {
+			return nil, err
+		}
+		idfSum += idf
+	}
+
+	anchorScores := make(map[string]float64)
+	if idfSum == 0 {
+		return anchorScores, nil
+	}
+
+	for link := range linkScores {
+		var sum float64
+		for _, word := range words {
+			score, err := db.client.ZScore(db.ctx, anchorKey(word), link).Result()
+			if err == redis.Nil {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("error fetching anchor score for word %s in link %s: %w", word, link, err)
+			}
+			sum += score
+		}
+		anchorScores[link] = sum / idfSum
+	}
+
+	return anchorScores, nil
+}
+
+// GetPositions returns where word is on each of the links that has it
+func (db *DataBase) GetPositions(word string, links []string) (map[string][]int, error) {
+	positions := make(map[string][]int)
+	if len(links) == 0 {
+		return positions, nil
+	}
+
+	r, err := db.client.HMGet(db.ctx, db.indexKey("positions:"+word), links...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching positions of word %s: %w", word, err)
+	}
+
+	for i, value := range r {
+		encoded, ok := value.(string)
+		if !ok {
+			continue
+		}
+		decoded, err := utils.DecodePositions(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode positions of word %s in link %s: %w", word, links[i], err)
+		}
+		positions[links[i]] = decoded
+	}
+
+	return positions, nil
+}
+
+// GetCommonLinks returns up to limit links that have every one of the words, scored by their summed TF-IDF.
+// Only links in lang are returned unless lang is empty.
+func (db *DataBase) GetCommonLinks(words []string, limit int64, lang string) (map[string]float64, error) {
+	if len(words) == 0 {
+		return make(map[string]float64), nil
+	}
+
+	tfidfKeys := make([]string, len(words))
+	for i, word := range words {
+		tfidfKeys[i] = fmt.Sprintf("tfidf:%s", word)
+	}
+
+	tempKey := fmt.Sprintf("temp:inter:%d", time.Now().UnixNano())
+	err := db.client.ZInterStore(db.ctx, tempKey, &redis.ZStore{
+		Keys:      tfidfKeys,
+		Aggregate: "SUM",
+	}).Err()
+	if err != nil {
+		return nil, fmt.Errorf("redis ZINTERSTORE failed: %w", err)
+	}
+	defer db.client.Del(db.ctx, tempKey)
+
+	if err = db.restrictLanguage(tempKey, lang); err != nil {
+		return nil, err
+	}
+
+	results, err := db.client.ZRevRangeWithScores(db.ctx, tempKey, 0, limit-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis ZRevRangeWithScores failed: %w", err)
+	}
+
+	linkScores := make(map[string]float64)
+	for _, z := range results {
+		link, ok := z.Member.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string member in ZSET but got %T", z.Member)
+		}
+		linkScores[link] = z.Score
+	}
+
+	return linkScores, nil
+}
@@ -3,6 +3,9 @@ package database
 import (
 	"fmt"
 	"testing"
+	"utils"
+
+	"github.com/redis/go-redis/v9"
 )
 
 func TestGetCandidateLinks(t *testing.T) {
@@ -15,10 +18,62 @@ func TestGetCandidateLinks(t *testing.T) {
 
 	words := []string{"osu"}	
 
-	candidateLinks, err := db.GetCandidateLinks(words, 100)
+	candidateLinks, err := db.GetCandidateLinks(words, 100, "")
 	if err != nil {
 		t.Error(err)
 	}
 
 	fmt.Println(candidateLinks)
 }
+
+func TestGetCandidateLinksLanguage(t *testing.T) {
+	db := DataBase{}
+
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to database %v", err)
+	}
+
+	db.client.ZAdd(db.ctx, "tfidf:osu", redis.Z{Score: 2, Member: "https://osu.ppy.sh/fr"}, redis.Z{Score: 3, Member: "https://osu.ppy.sh/en"})
+	db.client.ZAdd(db.ctx, "tfidf:jeu", redis.Z{Score: 1, Member: "https://osu.ppy.sh/fr"})
+	db.client.SAdd(db.ctx, "lang:fr", "https://osu.ppy.sh/fr")
+
+	candidateLinks, err := db.GetCandidateLinks([]string{"osu", "jeu"}, 100, "fr")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(candidateLinks) != 1 || candidateLinks["https://osu.ppy.sh/fr"] != 3 {
+		t.Errorf("expected only the french link with its scores got %v", candidateLinks)
+	}
+
+	commonLinks, err := db.GetCommonLinks([]string{"osu"}, 100, "de")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(commonLinks) != 0 {
+		t.Errorf("expected no link in german got %v", commonLinks)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestIndexVersion(t *testing.T) {
+	db := DataBase{}
+
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to database %v", err)
+	}
+
+	link := "https://osu.ppy.sh/home"
+	db.client.HSet(db.ctx, "document:"+utils.HashUrl(link), "length", 1, "title", "old")
+	db.client.HSet(db.ctx, "v2:document:"+utils.HashUrl(link), "length", 2, "title", "new")
+	db.client.Set(db.ctx, utils.IndexVersionKey, "v2:", 0)
+
+	document, err := db.GetDocument(link)
+	if err != nil || document.Title != "new" {
+		t.Errorf("expected the document of the live version got %v %v", document, err)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
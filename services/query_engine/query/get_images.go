@@ -4,11 +4,11 @@ import (
 	"fmt"
 	"query_engine/database"
 	"sort"
-	"utils"
+	"utils/analyzer"
 )
 
 func GetImages(db *database.DataBase, query string) ([]string, error) {
-	words := utils.NormalizeQuery(query)
+	words := analyzer.Default.Analyze(query)
 
 	// Map[imageURL] = {totalTF, matchedTerms}
 	type imageScore struct {
@@ -0,0 +1,138 @@
+package query
+
+import (
+	"query_engine/database"
+	"regexp"
+	"slices"
+	"strings"
+	"utils/analyzer"
+)
+
+// lang:<code> restricts a query to documents in that language
+var langFilter = regexp.MustCompile(`(?i)(^|\s)lang:(\S*)`)
+
+// ParseQuery splits a query into all of its words and the phrases in double
+// quotes, a phrase of a single word is just a word. An unclosed quote runs
+// to the end of the query. The words are analyzed in the language of the
+// last lang: filter, lang is empty if there is none.
+// Without a filter they are analyzed as english and as the language the query
+// is detected to be in, documents in that language were indexed with its analyzer.
+func ParseQuery(query string) (words []string, phrases [][]string, lang string) {
+	for _, match := range langFilter.FindAllStringSubmatch(query, -1) {
+		lang = analyzer.NormalizeLanguage(match[2])
+	}
+	query = langFilter.ReplaceAllString(query, " ")
+
+	a := analyzer.For(lang)
+	parts := strings.Split(query, `"`)
+	text := strings.Join(parts, " ")
+
+	words = a.Analyze(text)
+	if lang == "" {
+		//phrases have to be in the terms their documents were indexed with
+		a = analyzer.For(analyzer.DetectLanguage("", text))
+		for _, word := range a.Analyze(text) {
+			if !slices.Contains(words, word) {
+				words = append(words, word)
+			}
+		}
+	}
+	for i := 1; i < len(parts); i += 2 {
+		if phrase := a.Analyze(parts[i]); len(phrase) > 1 {
+			phrases = append(phrases, phrase)
+		}
+	}
+
+	return words, phrases, lang
+}
+
+// matchesPhrase reports if the terms appear one after the other,
+// positions holds the positions of every term of the phrase in order
+func matchesPhrase(positions [][]int) bool {
+	if len(positions) == 0 {
+		return false
+	}
+
+	for _, start := range positions[0] {
+		match := true
+		for i := 1; i < len(positions) && match; i++ {
+			_, match = slices.BinarySearch(positions[i], start+i)
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// proximity scores how close together the terms are, 1 if the terms found
+// are next to each other in the smallest span holding one of each, falling
+// towards 0 as they spread out. Fewer than two terms found score 0.
+func proximity(positions [][]int) float64 {
+	type occurrence struct {
+		position int
+		term     int
+	}
+
+	occurrences := []occurrence{}
+	found := 0
+	for term, termPositions := range positions {
+		if len(termPositions) > 0 {
+			found++
+		}
+		for _, position := range termPositions {
+			occurrences = append(occurrences, occurrence{position, term})
+		}
+	}
+	if found < 2 {
+		return 0
+	}
+	slices.SortFunc(occurrences, func(a, b occurrence) int { return a.position - b.position })
+
+	//sliding window over the occurrences that holds every found term
+	counts := make([]int, len(positions))
+	covered := 0
+	bestSpan := -1
+	start := 0
+	for _, o := range occurrences {
+		if counts[o.term] == 0 {
+			covered++
+		}
+		counts[o.term]++
+
+		for covered == found {
+			span := o.position - occurrences[start].position
+			if bestSpan < 0 || span < bestSpan {
+				bestSpan = span
+			}
+			first := occurrences[start].term
+			counts[first]--
+			if counts[first] == 0 {
+				covered--
+			}
+			start++
+		}
+	}
+
+	return float64(found-1) / float64(bestSpan)
+}
+
+// positionsOf returns link -> positions of every word on the page, in the order of words
+func positionsOf(db *database.DataBase, words []string, links []string) (map[string][][]int, error) {
+	positions := make(map[string][][]int)
+	for _, link := range links {
+		positions[link] = make([][]int, len(words))
+	}
+
+	for i, word := range words {
+		byLink, err := db.GetPositions(word, links)
+		if err != nil {
+			return nil, err
+		}
+		for link, wordPositions := range byLink {
+			positions[link][i] = wordPositions
+		}
+	}
+
+	return positions, nil
+}
@@ -0,0 +1,101 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+	"utils/analyzer/analyzertest"
+)
+
+func TestParseQuery(t *testing.T) {
+	words, phrases, lang := ParseQuery(`"osu game" download "client`)
+
+	if !reflect.DeepEqual(words, []string{"osu", "game", "download", "client"}) {
+		t.Errorf("unexpected words %v", words)
+	}
+	//the unclosed quote has a single word
+	if !reflect.DeepEqual(phrases, [][]string{{"osu", "game"}}) {
+		t.Errorf("unexpected phrases %v", phrases)
+	}
+	if lang != "" {
+		t.Errorf("expected no language got %q", lang)
+	}
+}
+
+func TestParseQueryLanguage(t *testing.T) {
+	tests := []struct {
+		query   string
+		words   []string
+		phrases [][]string
+		lang    string
+	}{
+		{`lang:fr les "joueurs rapides"`, []string{"le", "joueur", "rapid"}, [][]string{{"joueur", "rapid"}}, "fr"},
+		{`players LANG:en-GB`, []string{"player"}, nil, "en"},
+		//the last filter wins
+		{`lang:fr jugadores lang:es`, []string{"jugador"}, nil, "es"},
+		//not a language, analyzed as english
+		{`lang:french players`, []string{"player"}, nil, ""},
+		{`golang:fr`, []string{"golangfr"}, nil, ""},
+		//no filter, looked up as english and as the detected french but not filtered by it
+		{`"les joueurs" jouaient aux cartes`, []string{"les", "joueur", "jouaient", "aux", "cart", "le", "jou"}, [][]string{{"le", "joueur"}}, ""},
+	}
+
+	for _, test := range tests {
+		words, phrases, lang := ParseQuery(test.query)
+		if !reflect.DeepEqual(words, test.words) || !reflect.DeepEqual(phrases, test.phrases) || lang != test.lang {
+			t.Errorf("%q: expected %v %v %q got %v %v %q", test.query, test.words, test.phrases, test.lang, words, phrases, lang)
+		}
+	}
+}
+
+func TestMatchesPhrase(t *testing.T) {
+	tests := []struct {
+		positions [][]int
+		expected  bool
+	}{
+		{[][]int{{3, 20}, {21}}, true},
+		{[][]int{{3, 20}, {5, 22}}, false},
+		{[][]int{{1}, {2}, {4}}, false},
+		{[][]int{{1, 8}, {2, 9}, {10}}, true},
+		{[][]int{{1}, {}}, false},
+	}
+
+	for _, test := range tests {
+		if got := matchesPhrase(test.positions); got != test.expected {
+			t.Errorf("%v: expected %v got %v", test.positions, test.expected, got)
+		}
+	}
+}
+
+func TestProximity(t *testing.T) {
+	tests := []struct {
+		positions [][]int
+		expected  float64
+	}{
+		{[][]int{{3, 50}, {51}}, 1},
+		{[][]int{{0}, {10}}, 0.1},
+		{[][]int{{0, 30}, {12}, {32}}, 0.1},
+		//missing terms are left out
+		{[][]int{{5}, {}, {7}}, 0.5},
+		{[][]int{{5}, {}}, 0},
+	}
+
+	for _, test := range tests {
+		if got := proximity(test.positions); got != test.expected {
+			t.Errorf("%v: expected %v got %v", test.positions, test.expected, got)
+		}
+	}
+}
+
+// query time analysis has to give the terms the crawler indexed for the same text
+func TestParseQueryGolden(t *testing.T) {
+	cases, err := analyzertest.ReadGolden(analyzertest.GoldenFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		if words, _, _ := ParseQuery(c.Input); !reflect.DeepEqual(words, c.Terms) {
+			t.Errorf("%q: expected %v got %v", c.Input, c.Terms, words)
+		}
+	}
+}
@@ -1,15 +1,31 @@
 package query
 
 import (
+	"maps"
 	"query_engine/database"
+	"slices"
 	"sort"
-	"utils"
 )
 
+// how much the text of links pointing at a page counts next to its own content
+const anchorWeight = 0.5
+
+// how much query words being close together on a page counts
+const proximityWeight = 0.3
+
+// pages with every word of the phrases that are checked for the phrases
+const phraseCandidates = 1000
+
 func GetRelevantUrls(query string, db *database.DataBase, UrlReturnCount int) ([]string, error) {
-	words := utils.NormalizeQuery(query)
+	words, phrases, lang := ParseQuery(query)
 
-	candidateLinks, err := db.GetCandidateLinks(words, 100)
+	var candidateLinks map[string]float64
+	var err error
+	if len(phrases) > 0 {
+		candidateLinks, err = phraseLinks(db, phrases, lang)
+	} else {
+		candidateLinks, err = db.GetCandidateLinks(words, 100, lang)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -19,6 +35,16 @@ func GetRelevantUrls(query string, db *database.DataBase, UrlReturnCount int) ([
 		return nil, err
 	}
 
+	anchorScores, err := db.GetAnchorScores(words, candidateLinks)
+	if err != nil {
+		return nil, err
+	}
+
+	proximityScores, err := proximityScores(db, words, candidateLinks)
+	if err != nil {
+		return nil, err
+	}
+
 	type kv struct {
 		key string
 		val float64
@@ -28,7 +54,7 @@ func GetRelevantUrls(query string, db *database.DataBase, UrlReturnCount int) ([
 	for url, score := range cosineScores {
 		results = append(results, kv{
 			key: url,
-			val: score,
+			val: score + anchorWeight*anchorScores[url] + proximityWeight*proximityScores[url],
 		})
 	}
 
@@ -45,3 +71,50 @@ func GetRelevantUrls(query string, db *database.DataBase, UrlReturnCount int) ([
 
 	return links, nil
 }
+
+// returns the links in lang that have every phrase, in any language if lang is empty
+func phraseLinks(db *database.DataBase, phrases [][]string, lang string) (map[string]float64, error) {
+	phraseWords := []string{}
+	for _, phrase := range phrases {
+		phraseWords = append(phraseWords, phrase...)
+	}
+
+	candidateLinks, err := db.GetCommonLinks(phraseWords, phraseCandidates, lang)
+	if err != nil {
+		return nil, err
+	}
+
+	links := slices.Collect(maps.Keys(candidateLinks))
+	for _, phrase := range phrases {
+		positions, err := positionsOf(db, phrase, links)
+		if err != nil {
+			return nil, err
+		}
+		for link, linkPositions := range positions {
+			if !matchesPhrase(linkPositions) {
+				delete(candidateLinks, link)
+			}
+		}
+	}
+
+	return candidateLinks, nil
+}
+
+func proximityScores(db *database.DataBase, words []string, linkScores map[string]float64) (map[string]float64, error) {
+	//a repeated word would only ever be next to itself
+	words = slices.Compact(slices.Sorted(slices.Values(words)))
+	if len(words) < 2 {
+		return map[string]float64{}, nil
+	}
+
+	positions, err := positionsOf(db, words, slices.Collect(maps.Keys(linkScores)))
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]float64)
+	for link, linkPositions := range positions {
+		scores[link] = proximity(linkPositions)
+	}
+	return scores, nil
+}
@@ -4,4 +4,5 @@ type Document struct {
 	NormUrl string
 	Length  int
 	Title   string
-}
\ No newline at end of file
+	Lang    string
+}
@@ -6,4 +6,6 @@ type InvertedIndex map[string][]Posting
 type Posting struct {
 	NormUrl       string
 	TermFrequency int
+	//field -> frequency, they add up to TermFrequency
+	Fields map[string]int
 }
@@ -16,6 +16,8 @@ import (
 type DataBase struct {
 	client *redis.Client
 	ctx    context.Context
+	//the live index the crawler wrote is kept under it, see utils.IndexVersionKey
+	indexPrefix string
 }
 
 func (db *DataBase) Connect(addr string, database string, password string) error {
@@ -37,11 +39,21 @@ func (db *DataBase) Connect(addr string, database string, password string) error
 		return fmt.Errorf("couldn't connect do db %v %v", addr, err)
 	}
 
+	//a run scores the index that was live when it started
+	db.indexPrefix, err = db.client.Get(db.ctx, utils.IndexVersionKey).Result()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("could not get %v %v", utils.IndexVersionKey, err)
+	}
+
 	return nil
 }
 
+func (db *DataBase) indexKey(key string) string {
+	return db.indexPrefix + key
+}
+
 func (db *DataBase) GetDocLength(normUrl string) (int64, error) {
-	key := "document:" + utils.HashUrl(normUrl)
+	key := db.indexKey("document:" + utils.HashUrl(normUrl))
 	r, err := db.client.HGet(db.ctx, key, "length").Result()
 	if err != nil {
 		return 0, fmt.Errorf("could not get length of document %v from db %v", normUrl, err)
@@ -58,7 +70,7 @@ func (db *DataBase) GetDocLength(normUrl string) (int64, error) {
 
 func (db *DataBase) GetIndices() ([]querytypes.WordIndex, error) {
 
-	keys, err := db.client.Keys(db.ctx, "index:*").Result()
+	keys, err := db.client.Keys(db.ctx, db.indexKey("index:*")).Result()
 	if err != nil {
 		return nil, fmt.Errorf("could not get all keys for index %v", err)
 	}
@@ -66,7 +78,7 @@ func (db *DataBase) GetIndices() ([]querytypes.WordIndex, error) {
 	indices := make([]querytypes.WordIndex, len(keys))
 	for i, key := range keys {
 		r, err := db.client.ZRevRangeWithScores(db.ctx, key, 0, -1).Result()
-		word := strings.Split(key, ":")[1]
+		word := strings.TrimPrefix(key, db.indexKey("index:"))
 		if err != nil {
 			return nil, fmt.Errorf("could not retrieve indices for word %v from db %v", word, err)
 		}
@@ -93,7 +105,7 @@ func (db *DataBase) GetIndices() ([]querytypes.WordIndex, error) {
 }
 
 func (db *DataBase) GetDocsCount() (int64, error) {
-	r, err := db.client.Get(db.ctx, "domain:count").Result()
+	r, err := db.client.Get(db.ctx, db.indexKey("domain:count")).Result()
 	if err != nil {
 		return 0, fmt.Errorf("could not get domain count from db %v", err)
 	}
@@ -142,6 +154,14 @@ func (db *DataBase) StreamIndices(batchSize int) error {
 					Score:  tfidf,
 				})
 
+				//same idf and document length, the field scores add up to tfidf
+				for field, frequency := range posting.Fields {
+					pipe.ZAdd(db.ctx, "fieldtfidf:"+field+":"+index.Word, redis.Z{
+						Member: posting.NormUrl,
+						Score:  Tfidf(frequency, int(docLength), int(docsCount), len(index.Postings)),
+					})
+				}
+
 				docMagnitudeSums[posting.NormUrl] += tfidf * tfidf
 			}
 		}
@@ -164,7 +184,7 @@ func (db *DataBase) StreamIndices(batchSize int) error {
 	var cursor uint64
 	for {
 		log.Printf("Processing indices cursor: %d\n", cursor)
-		keys, nextCursor, err := db.client.Scan(db.ctx, cursor, "index:*", int64(batchSize)).Result()
+		keys, nextCursor, err := db.client.Scan(db.ctx, cursor, db.indexKey("index:*"), int64(batchSize)).Result()
 		if err != nil {
 			return fmt.Errorf("could not scan keys: %v", err)
 		}
@@ -176,7 +196,7 @@ func (db *DataBase) StreamIndices(batchSize int) error {
 				return fmt.Errorf("could not get ZSET for key %s: %v", key, err)
 			}
 
-			word := strings.TrimPrefix(key, "index:")
+			word := strings.TrimPrefix(key, db.indexKey("index:"))
 			index := querytypes.WordIndex{Word: word}
 
 			for _, z := range r {
@@ -189,6 +209,10 @@ func (db *DataBase) StreamIndices(batchSize int) error {
 					TermFrequency: int(z.Score),
 				})
 			}
+
+			if err = db.addFields(&index); err != nil {
+				return err
+			}
 			batch = append(batch, index)
 		}
 
@@ -204,6 +228,102 @@ func (db *DataBase) StreamIndices(batchSize int) error {
 	return nil
 }
 
+// StreamAnchors scores the anchor text postings, a term's frequency is
+// relative to all anchor text pointing at the page. Pages that weren't
+// crawled have no document, their anchors are all there is to find them by.
+func (db *DataBase) StreamAnchors(batchSize int) error {
+	docsCount, err := db.GetDocsCount()
+	if err != nil {
+		return err
+	}
+
+	prefix := db.indexKey("fieldindex:" + utils.FieldAnchor + ":")
+
+	var cursor uint64
+	for {
+		log.Printf("Processing anchors cursor: %d\n", cursor)
+		keys, nextCursor, err := db.client.Scan(db.ctx, cursor, prefix+"*", int64(batchSize)).Result()
+		if err != nil {
+			return fmt.Errorf("could not scan keys: %v", err)
+		}
+
+		pipe := db.client.Pipeline()
+		for _, key := range keys {
+			word := strings.TrimPrefix(key, prefix)
+
+			r, err := db.client.ZRangeWithScores(db.ctx, key, 0, -1).Result()
+			if err != nil {
+				return fmt.Errorf("could not get ZSET for key %s: %v", key, err)
+			}
+			docsWithTerm, err := db.client.ZCard(db.ctx, db.indexKey("index:"+word)).Result()
+			if err != nil {
+				return fmt.Errorf("could not get ZSET size for key index:%s: %v", word, err)
+			}
+
+			//a word only found in anchor text has no idf from the documents yet
+			pipe.ZAddNX(db.ctx, "idf", redis.Z{
+				Member: word,
+				Score:  inverseDocumentFrequency(int(docsCount), int(docsWithTerm)),
+			})
+
+			for _, z := range r {
+				normUrl, ok := z.Member.(string)
+				if !ok {
+					return fmt.Errorf("expected string member but got %T", z.Member)
+				}
+
+				anchorLength, err := db.client.ZScore(db.ctx, db.indexKey("anchorlength"), normUrl).Result()
+				if err != nil {
+					return fmt.Errorf("failed to get anchor length for %s: %w", normUrl, err)
+				}
+
+				pipe.ZAdd(db.ctx, "fieldtfidf:"+utils.FieldAnchor+":"+word, redis.Z{
+					Member: normUrl,
+					Score:  Tfidf(int(z.Score), int(anchorLength), int(docsCount), int(docsWithTerm)),
+				})
+			}
+		}
+
+		if _, err := pipe.Exec(db.ctx); err != nil {
+			return fmt.Errorf("failed to execute redis pipeline: %w", err)
+		}
+
+		if nextCursor == 0 {
+			break
+		}
+		cursor = nextCursor
+	}
+	return nil
+}
+
+// adds the frequency of the word in every field to its postings
+func (db *DataBase) addFields(index *querytypes.WordIndex) error {
+	fields := make(map[string]map[string]int)
+	for _, field := range utils.IndexFields {
+		key := db.indexKey("fieldindex:" + field + ":" + index.Word)
+		r, err := db.client.ZRangeWithScores(db.ctx, key, 0, -1).Result()
+		if err != nil {
+			return fmt.Errorf("could not get ZSET for key %s: %v", key, err)
+		}
+
+		for _, z := range r {
+			normUrl, ok := z.Member.(string)
+			if !ok {
+				return fmt.Errorf("expected string member but got %T", z.Member)
+			}
+			if fields[normUrl] == nil {
+				fields[normUrl] = make(map[string]int)
+			}
+			fields[normUrl][field] = int(z.Score)
+		}
+	}
+
+	for i := range index.Postings {
+		index.Postings[i].Fields = fields[index.Postings[i].NormUrl]
+	}
+	return nil
+}
+
 func relativeFrequency(termFrequency int, docLength int) float64 {
 	return float64(termFrequency) / float64(docLength)
 }
@@ -20,4 +20,9 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+
+	err = db.StreamAnchors(1000)
+	if err != nil {
+		panic(err)
+	}
 }
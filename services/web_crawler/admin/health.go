@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+	"web_crawler/metrics"
+)
+
+// a crawl with work left that got no response for this long is stalled
+const stallTimeout = 10 * time.Minute
+
+// healthz fails when redis can't be reached, nothing works without it
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.Ping(); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"redis": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"redis": "ok"})
+}
+
+// readyz also fails when workers are running but the crawl stopped making progress.
+// a paused crawl or one without workers is idle on purpose and stays ready.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	status := http.StatusOK
+
+	if err := s.db.Ping(); err != nil {
+		checks["redis"] = err.Error()
+		writeJSON(w, http.StatusServiceUnavailable, checks)
+		return
+	}
+	checks["redis"] = "ok"
+
+	checks["crawl"] = "ok"
+	idle := time.Since(metrics.LastResponse())
+	if s.pool.Size() > 0 && !s.pool.Paused() && idle > stallTimeout {
+		//urls of hosts waiting on a crawl delay or retry-after aren't work that should be happening now,
+		//only a host that has been ready all along is
+		overdue, err := s.db.OverdueFor()
+		switch {
+		case err != nil:
+			checks["crawl"] = err.Error()
+			status = http.StatusServiceUnavailable
+		case overdue > stallTimeout:
+			checks["crawl"] = "stalled, no response for " + idle.Truncate(time.Second).String()
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, status, checks)
+}
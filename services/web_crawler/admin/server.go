@@ -0,0 +1,371 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"web_crawler/config"
+	"web_crawler/crawler"
+	"web_crawler/database"
+	"web_crawler/types"
+	"web_crawler/utilities"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// how many hosts and errors are listed when the request doesn't say
+const defaultListCount = 100
+
+// Server is the http api that steers a running crawler
+type Server struct {
+	db   *database.DataBase
+	pool *crawler.Pool
+	//empty disables authentication
+	token string
+}
+
+func New(db *database.DataBase, pool *crawler.Pool, token string) *Server {
+	return &Server{db: db, pool: pool, token: token}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /status", s.status)
+	mux.HandleFunc("POST /seeds", s.addSeeds)
+	mux.HandleFunc("POST /pause", s.pause)
+	mux.HandleFunc("POST /resume", s.resume)
+	mux.HandleFunc("PUT /workers", s.setWorkers)
+	mux.HandleFunc("GET /domains", s.listDomains)
+	mux.HandleFunc("GET /domains/{host}", s.getDomain)
+	mux.HandleFunc("POST /domains/{host}/block", s.blockDomain)
+	mux.HandleFunc("DELETE /domains/{host}/block", s.unblockDomain)
+	mux.HandleFunc("DELETE /domains/{host}/queue", s.purgeDomain)
+	mux.HandleFunc("GET /errors", s.recentErrors)
+	mux.HandleFunc("GET /traps", s.spiderTraps)
+	mux.HandleFunc("DELETE /traps", s.clearSpiderTrap)
+
+	//monitoring is left open so scrapers and probes don't need the token
+	root := http.NewServeMux()
+	root.Handle("GET /metrics", promhttp.Handler())
+	root.HandleFunc("GET /healthz", s.healthz)
+	root.HandleFunc("GET /readyz", s.readyz)
+	root.Handle("/", s.authenticate(mux))
+
+	return root
+}
+
+// requires "Authorization: Bearer <token>" if the server has a token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			expected := []byte("Bearer " + s.token)
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("missing or wrong token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	queued, err := s.db.UrlQueueLength()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	pending, err := crawler.PendingWork(s.db)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	skipped, err := s.db.SkippedCounts()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	outOfScope, err := s.db.OutOfScopeCounts()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"workers":      s.pool.Size(),
+		"paused":       s.pool.Paused(),
+		"queue_length": queued,
+		"pending":      pending,
+		"skipped":      skipped,
+		"out_of_scope": outOfScope,
+	})
+}
+
+func (s *Server) addSeeds(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Urls []string `json:"urls"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	added := []string{}
+	rejected := map[string]string{}
+	for _, seed := range body.Urls {
+		normUrl, err := utilities.CanonicalizeUrl(seed)
+		if err != nil || normUrl == "" {
+			rejected[seed] = "not an http(s) url"
+			continue
+		}
+
+		var scopeErr *database.ScopeError
+		err = s.db.PushUrl(normUrl, 0)
+		switch {
+		case errors.As(err, &scopeErr):
+			rejected[seed] = string(scopeErr.Reason)
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		default:
+			added = append(added, normUrl)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"added":    added,
+		"rejected": rejected,
+	})
+}
+
+func (s *Server) pause(w http.ResponseWriter, r *http.Request) {
+	s.pool.Pause()
+	log.Println("crawl paused through the admin api")
+	writeJSON(w, http.StatusOK, map[string]any{"paused": true})
+}
+
+func (s *Server) resume(w http.ResponseWriter, r *http.Request) {
+	s.pool.Resume()
+	log.Println("crawl resumed through the admin api")
+	writeJSON(w, http.StatusOK, map[string]any{"paused": false})
+}
+
+func (s *Server) setWorkers(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Count *int `json:"count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Count == nil || *body.Count < 0 || *body.Count > config.MaxWorkers {
+		writeError(w, http.StatusBadRequest, errors.New("count has to be between 0 and "+strconv.Itoa(config.MaxWorkers)))
+		return
+	}
+
+	s.pool.Resize(*body.Count)
+	log.Printf("worker count set to %d through the admin api\n", *body.Count)
+	writeJSON(w, http.StatusOK, map[string]any{"workers": s.pool.Size()})
+}
+
+func (s *Server) listDomains(w http.ResponseWriter, r *http.Request) {
+	count, err := countParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	hosts, err := s.db.ReadyHosts(count)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, hosts)
+}
+
+func (s *Server) getDomain(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("host")
+
+	exists, err := s.db.DomainExists(host)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	//a host can be queued or blocked before its robots.txt was fetched
+	domain := types.Domain{Name: host}
+	if exists {
+		domain, err = s.db.GetDomain(host)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	queued, err := s.db.HostQueueLength(host)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	pages, err := s.db.DomainPages(host)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	blocked, err := s.db.DomainBlocked(host)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !exists && queued == 0 && pages == 0 && !blocked {
+		writeError(w, http.StatusNotFound, errors.New("unknown domain "+host))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"domain":  domain,
+		"queued":  queued,
+		"pages":   pages,
+		"blocked": blocked,
+	})
+}
+
+func (s *Server) blockDomain(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("host")
+
+	purged, err := s.db.BlockDomain(host)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("domain: %v blocked through the admin api, %d queued urls dropped\n", host, purged)
+	writeJSON(w, http.StatusOK, map[string]any{"blocked": true, "purged": purged})
+}
+
+func (s *Server) unblockDomain(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.UnblockDomain(r.PathValue("host")); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"blocked": false})
+}
+
+func (s *Server) purgeDomain(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("host")
+
+	purged, err := s.db.PurgeHost(host)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("queue of domain: %v purged through the admin api, %d urls dropped\n", host, purged)
+	writeJSON(w, http.StatusOK, map[string]any{"purged": purged})
+}
+
+func (s *Server) recentErrors(w http.ResponseWriter, r *http.Request) {
+	count, err := countParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	crawlErrors, err := s.db.RecentErrors(count)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	deadLetters, err := s.db.DeadLetters(0, count-1)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"recent":       crawlErrors,
+		"dead_letters": deadLetters,
+	})
+}
+
+func (s *Server) spiderTraps(w http.ResponseWriter, r *http.Request) {
+	traps, err := s.db.SpiderTraps()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, traps)
+}
+
+func (s *Server) clearSpiderTrap(w http.ResponseWriter, r *http.Request) {
+	template := r.URL.Query().Get("template")
+	if template == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing template parameter"))
+		return
+	}
+
+	if err := s.db.ClearSpiderTrap(template); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"cleared": template})
+}
+
+// reads the optional count query parameter
+func countParam(r *http.Request) (int64, error) {
+	value := r.URL.Query().Get("count")
+	if value == "" {
+		return defaultListCount, nil
+	}
+
+	count, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || count <= 0 {
+		return 0, errors.New("count has to be a positive number")
+	}
+	return count, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("could not write admin response %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		log.Printf("admin api error: %v", err)
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// ListenAndServe serves the api on addr until the server is shut down
+func ListenAndServe(addr string, s *Server) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Printf("admin api listening on %v\n", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("admin api stopped %v", err)
+		}
+	}()
+
+	return server
+}
@@ -0,0 +1,111 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"web_crawler/crawler"
+	"web_crawler/database"
+)
+
+func request(t *testing.T, handler http.Handler, method string, target string, body string, token string) (int, map[string]any) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	res := map[string]any{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("%v %v: invalid json %v", method, target, rec.Body.String())
+	}
+	return rec.Code, res
+}
+
+func TestPauseResume(t *testing.T) {
+	pool := crawler.NewPool(context.Background(), nil, "test")
+	handler := New(nil, pool, "").Handler()
+
+	code, res := request(t, handler, http.MethodPost, "/pause", "", "")
+	if code != http.StatusOK || res["paused"] != true || !pool.Paused() {
+		t.Errorf("expected pool to be paused got %d %v", code, res)
+	}
+
+	code, res = request(t, handler, http.MethodPost, "/resume", "", "")
+	if code != http.StatusOK || res["paused"] != false || pool.Paused() {
+		t.Errorf("expected pool to be resumed got %d %v", code, res)
+	}
+}
+
+func TestSetWorkersValidates(t *testing.T) {
+	pool := crawler.NewPool(context.Background(), nil, "test")
+	handler := New(nil, pool, "").Handler()
+
+	for _, body := range []string{`{}`, `{"count": -1}`, `{"count": 100000}`, `not json`} {
+		if code, _ := request(t, handler, http.MethodPut, "/workers", body, ""); code != http.StatusBadRequest {
+			t.Errorf("%v: expected bad request got %d", body, code)
+		}
+	}
+
+	code, res := request(t, handler, http.MethodPut, "/workers", `{"count": 0}`, "")
+	if code != http.StatusOK || res["workers"] != float64(0) {
+		t.Errorf("expected 0 workers got %d %v", code, res)
+	}
+}
+
+func TestToken(t *testing.T) {
+	pool := crawler.NewPool(context.Background(), nil, "test")
+	handler := New(nil, pool, "secret").Handler()
+
+	if code, _ := request(t, handler, http.MethodPost, "/pause", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized without token got %d", code)
+	}
+	if code, _ := request(t, handler, http.MethodPost, "/pause", "", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized with wrong token got %d", code)
+	}
+	if code, _ := request(t, handler, http.MethodPost, "/pause", "", "secret"); code != http.StatusOK {
+		t.Errorf("expected ok with token got %d", code)
+	}
+}
+
+func TestHealthUnreachableRedis(t *testing.T) {
+	//the client stays set when the first ping fails, nothing listens on port 1
+	db := &database.DataBase{}
+	if err := db.Connect("localhost:1", "0", ""); err == nil {
+		t.Skip("something is listening on localhost:1")
+	}
+
+	pool := crawler.NewPool(context.Background(), nil, "test")
+	handler := New(db, pool, "secret").Handler()
+
+	//probes don't send the token
+	for _, target := range []string{"/healthz", "/readyz"} {
+		code, res := request(t, handler, http.MethodGet, target, "", "")
+		if code != http.StatusServiceUnavailable || res["redis"] == "ok" {
+			t.Errorf("%v: expected redis to be unavailable got %d %v", target, code, res)
+		}
+	}
+}
+
+func TestMetricsWithoutToken(t *testing.T) {
+	pool := crawler.NewPool(context.Background(), nil, "test")
+	handler := New(nil, pool, "secret").Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected ok got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "crawler_fetch_duration_seconds") {
+		t.Errorf("expected crawler metrics in %v", rec.Body.String())
+	}
+
+	//the rest of the api still needs the token
+	if code, _ := request(t, handler, http.MethodGet, "/status", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized without token got %d", code)
+	}
+}
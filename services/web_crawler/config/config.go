@@ -0,0 +1,348 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"web_crawler/pagestore"
+	"web_crawler/scope"
+	"web_crawler/types"
+	"web_crawler/utilities"
+
+	"gopkg.in/yaml.v3"
+)
+
+// read when neither --config nor CONFIG_FILE name a file, it may be missing
+const defaultFile = "crawler.yaml"
+
+// most workers one crawler instance may run
+const MaxWorkers = 256
+
+type Redis struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+func (r Redis) Addr() string {
+	return fmt.Sprintf("%v:%d", r.Host, r.Port)
+}
+
+type Crawler struct {
+	Workers   int    `yaml:"workers"`
+	UserAgent string `yaml:"user_agent"`
+	//how long fetching a page, robots.txt and a sitemap may take
+	FetchTimeout   time.Duration `yaml:"fetch_timeout"`
+	RobotsTimeout  time.Duration `yaml:"robots_timeout"`
+	SitemapTimeout time.Duration `yaml:"sitemap_timeout"`
+	//seconds between requests to a host whose robots.txt has no crawl-delay
+	DefaultCrawlDelay int64 `yaml:"default_crawl_delay"`
+	MaxBodyBytes      int64 `yaml:"max_body_bytes"`
+	//query parameters stripped from every url, a trailing * matches a prefix
+	TrackingParams []string `yaml:"tracking_params"`
+}
+
+type Warc struct {
+	//empty disables archiving
+	Dir string `yaml:"dir"`
+	//a file is finished and a new one started past this size
+	MaxFileBytes int64 `yaml:"max_file_bytes"`
+}
+
+type PageStore struct {
+	//none, redis or file
+	Kind string `yaml:"kind"`
+	//where the file store keeps pages
+	Dir string `yaml:"dir"`
+}
+
+type Admin struct {
+	Addr string `yaml:"addr"`
+	//empty disables authentication
+	Token string `yaml:"token"`
+}
+
+// Exposed reports if the admin api can be reached from other hosts
+func (a Admin) Exposed() bool {
+	host, _, err := net.SplitHostPort(a.Addr)
+	if err != nil {
+		return true
+	}
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip == nil || !ip.IsLoopback()
+}
+
+// Config is everything the crawler can be configured with. It is read from a
+// yaml file, then environment variables and then flags, each overriding the last.
+type Config struct {
+	Redis     Redis       `yaml:"redis"`
+	Crawler   Crawler     `yaml:"crawler"`
+	Warc      Warc        `yaml:"warc"`
+	PageStore PageStore   `yaml:"page_store"`
+	Admin     Admin       `yaml:"admin"`
+	Scope     types.Scope `yaml:"scope"`
+
+	//only set by --replay, the warc files in it are indexed instead of crawling
+	Replay string `yaml:"-"`
+	//only set by --reindex, the index is rebuilt from the page store instead of crawling
+	Reindex bool `yaml:"-"`
+}
+
+func Default() Config {
+	return Config{
+		Redis: Redis{
+			Host: "localhost",
+			Port: 6379,
+		},
+		Crawler: Crawler{
+			Workers:           5,
+			UserAgent:         "OrbBot/1.0",
+			FetchTimeout:      15 * time.Second,
+			RobotsTimeout:     10 * time.Second,
+			SitemapTimeout:    30 * time.Second,
+			DefaultCrawlDelay: 1,
+			MaxBodyBytes:      5 << 20,
+			TrackingParams:    slices.Clone(utilities.TrackingParams),
+		},
+		Warc: Warc{
+			MaxFileBytes: 1 << 30,
+		},
+		//pages are only kept when asked for, they take far more memory than the index
+		PageStore: PageStore{
+			Kind: pagestore.KindNone,
+		},
+		Admin: Admin{
+			Addr: "localhost:8081",
+		},
+		Scope: types.Scope{
+			Traps: types.Traps{TrapLimits: scope.DefaultTrapLimits},
+		},
+	}
+}
+
+// Load resolves the config from the file, getenv and args. The bool is true
+// if --print-config was passed.
+func Load(args []string, getenv func(string) string) (Config, bool, error) {
+	//the first pass only finds the config file, flags are parsed again on top of it
+	first := Default()
+	fs := flagSet(&first)
+	configFile := fs.String("config", getenv("CONFIG_FILE"), "yaml config file, env CONFIG_FILE")
+	printConfig := fs.Bool("print-config", false, "print the resolved config and exit")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+
+	cfg := Default()
+	if err := cfg.readFile(*configFile); err != nil {
+		return Config{}, false, err
+	}
+
+	if err := cfg.readEnv(getenv); err != nil {
+		return Config{}, false, err
+	}
+
+	fs = flagSet(&cfg)
+	fs.String("config", "", "")
+	fs.Bool("print-config", false, "")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+
+	return cfg, *printConfig, cfg.Validate()
+}
+
+func flagSet(cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("crawler", flag.ContinueOnError)
+
+	fs.StringVar(&cfg.Redis.Host, "redis-host", cfg.Redis.Host, "redis host, env REDIS_HOST")
+	fs.IntVar(&cfg.Redis.Port, "redis-port", cfg.Redis.Port, "redis port, env REDIS_PORT")
+	fs.StringVar(&cfg.Redis.Password, "redis-password", cfg.Redis.Password, "redis password, env REDIS_PASSWORD")
+	fs.IntVar(&cfg.Redis.DB, "redis-db", cfg.Redis.DB, "redis database, env REDIS_DB")
+
+	fs.IntVar(&cfg.Crawler.Workers, "workers", cfg.Crawler.Workers, "crawl workers, env WORKERS")
+	fs.StringVar(&cfg.Crawler.UserAgent, "user-agent", cfg.Crawler.UserAgent, "user agent, env USER_AGENT")
+	fs.DurationVar(&cfg.Crawler.FetchTimeout, "fetch-timeout", cfg.Crawler.FetchTimeout, "page fetch timeout, env FETCH_TIMEOUT")
+	fs.DurationVar(&cfg.Crawler.RobotsTimeout, "robots-timeout", cfg.Crawler.RobotsTimeout, "robots.txt fetch timeout, env ROBOTS_TIMEOUT")
+	fs.DurationVar(&cfg.Crawler.SitemapTimeout, "sitemap-timeout", cfg.Crawler.SitemapTimeout, "sitemap fetch timeout, env SITEMAP_TIMEOUT")
+	fs.Int64Var(&cfg.Crawler.DefaultCrawlDelay, "crawl-delay", cfg.Crawler.DefaultCrawlDelay, "seconds between requests to a host without a crawl-delay, env DEFAULT_CRAWL_DELAY")
+	fs.Int64Var(&cfg.Crawler.MaxBodyBytes, "max-body-bytes", cfg.Crawler.MaxBodyBytes, "largest page downloaded, env MAX_BODY_BYTES")
+
+	fs.StringVar(&cfg.Warc.Dir, "warc-dir", cfg.Warc.Dir, "directory fetched pages are archived to, env WARC_DIR")
+	fs.Int64Var(&cfg.Warc.MaxFileBytes, "warc-max-file-bytes", cfg.Warc.MaxFileBytes, "size a warc file is rotated at, env WARC_MAX_FILE_BYTES")
+	fs.StringVar(&cfg.Replay, "replay", cfg.Replay, "index the warc files in this directory without crawling and exit")
+
+	fs.StringVar(&cfg.PageStore.Kind, "page-store", cfg.PageStore.Kind, "where indexed pages are kept: none, redis or file, env PAGE_STORE")
+	fs.StringVar(&cfg.PageStore.Dir, "page-store-dir", cfg.PageStore.Dir, "directory of the file page store, env PAGE_STORE_DIR")
+	fs.BoolVar(&cfg.Reindex, "reindex", cfg.Reindex, "rebuild the index from the page store without crawling and exit")
+
+	fs.StringVar(&cfg.Admin.Addr, "admin-addr", cfg.Admin.Addr, "admin api address, env ADMIN_ADDR")
+	fs.StringVar(&cfg.Admin.Token, "admin-token", cfg.Admin.Token, "admin api bearer token, env ADMIN_TOKEN")
+
+	return fs
+}
+
+// a missing file is only an error if it was asked for
+func (cfg *Config) readFile(path string) error {
+	explicit := path != ""
+	if !explicit {
+		path = defaultFile
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not open config file %v %v", path, err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err = decoder.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("could not parse config file %v %v", path, err)
+	}
+
+	return nil
+}
+
+func (cfg *Config) readEnv(getenv func(string) string) error {
+	errs := []error{}
+
+	str := func(name string, dst *string) {
+		if value := getenv(name); value != "" {
+			*dst = value
+		}
+	}
+	num := func(name string, dst *int64) {
+		if value := getenv(name); value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%v has to be a number %v", name, err))
+				return
+			}
+			*dst = n
+		}
+	}
+	integer := func(name string, dst *int) {
+		n := int64(*dst)
+		num(name, &n)
+		*dst = int(n)
+	}
+	duration := func(name string, dst *time.Duration) {
+		if value := getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%v has to be a duration such as 15s %v", name, err))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	str("REDIS_HOST", &cfg.Redis.Host)
+	integer("REDIS_PORT", &cfg.Redis.Port)
+	str("REDIS_PASSWORD", &cfg.Redis.Password)
+	integer("REDIS_DB", &cfg.Redis.DB)
+
+	integer("WORKERS", &cfg.Crawler.Workers)
+	str("USER_AGENT", &cfg.Crawler.UserAgent)
+	duration("FETCH_TIMEOUT", &cfg.Crawler.FetchTimeout)
+	duration("ROBOTS_TIMEOUT", &cfg.Crawler.RobotsTimeout)
+	duration("SITEMAP_TIMEOUT", &cfg.Crawler.SitemapTimeout)
+	num("DEFAULT_CRAWL_DELAY", &cfg.Crawler.DefaultCrawlDelay)
+	num("MAX_BODY_BYTES", &cfg.Crawler.MaxBodyBytes)
+	//comma separated, replaces the list
+	if value := getenv("TRACKING_PARAMS"); value != "" {
+		cfg.Crawler.TrackingParams = strings.Split(value, ",")
+	}
+
+	str("WARC_DIR", &cfg.Warc.Dir)
+	num("WARC_MAX_FILE_BYTES", &cfg.Warc.MaxFileBytes)
+
+	str("PAGE_STORE", &cfg.PageStore.Kind)
+	str("PAGE_STORE_DIR", &cfg.PageStore.Dir)
+
+	str("ADMIN_ADDR", &cfg.Admin.Addr)
+	str("ADMIN_TOKEN", &cfg.Admin.Token)
+
+	return errors.Join(errs...)
+}
+
+// Validate returns every problem with the config at once
+func (cfg *Config) Validate() error {
+	errs := []error{}
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.Redis.Host != "", "redis.host can't be empty")
+	check(cfg.Redis.Port > 0 && cfg.Redis.Port < 1<<16, "redis.port has to be a tcp port, got %d", cfg.Redis.Port)
+	check(cfg.Redis.DB >= 0, "redis.db can't be negative")
+
+	check(cfg.Crawler.Workers >= 0 && cfg.Crawler.Workers <= MaxWorkers, "crawler.workers has to be between 0 and %d, got %d", MaxWorkers, cfg.Crawler.Workers)
+	check(strings.TrimSpace(cfg.Crawler.UserAgent) != "", "crawler.user_agent can't be empty")
+	check(cfg.Crawler.FetchTimeout > 0, "crawler.fetch_timeout has to be positive")
+	check(cfg.Crawler.RobotsTimeout > 0, "crawler.robots_timeout has to be positive")
+	check(cfg.Crawler.SitemapTimeout > 0, "crawler.sitemap_timeout has to be positive")
+	check(cfg.Crawler.DefaultCrawlDelay > 0, "crawler.default_crawl_delay has to be at least 1 second")
+	check(cfg.Crawler.MaxBodyBytes > 0, "crawler.max_body_bytes has to be positive")
+
+	check(cfg.Warc.MaxFileBytes > 0, "warc.max_file_bytes has to be positive")
+
+	kinds := []string{pagestore.KindNone, pagestore.KindRedis, pagestore.KindFile}
+	check(slices.Contains(kinds, cfg.PageStore.Kind), "page_store.kind has to be one of %v, got %q", kinds, cfg.PageStore.Kind)
+	check(cfg.PageStore.Kind != pagestore.KindFile || cfg.PageStore.Dir != "", "page_store.dir is needed by the file page store")
+	check(!cfg.Reindex || cfg.PageStore.Kind != pagestore.KindNone, "--reindex needs a page store")
+	check(!cfg.Reindex || cfg.Replay == "", "--reindex and --replay can't be used together")
+
+	check(cfg.Admin.Addr != "", "admin.addr can't be empty")
+
+	//replaying and reindexing don't crawl so they need no seeds
+	check(len(cfg.Scope.Seeds) > 0 || cfg.Replay != "" || cfg.Reindex, "scope.seeds can't be empty")
+	policy, err := scope.New(cfg.Scope)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("scope: %v", err))
+	}
+	for _, seed := range cfg.Scope.Seeds {
+		normUrl, err := utilities.CanonicalizeUrl(seed)
+		check(err == nil && normUrl != "", "scope.seeds: %v is not an http(s) url", seed)
+		//a seed the scope rejects would never be crawled
+		if policy != nil && normUrl != "" {
+			reason := policy.Check(normUrl, 0)
+			check(reason == scope.ReasonInScope, "scope.seeds: %v is out of scope: %v", seed, reason)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Print writes the config as yaml with secrets masked
+func (cfg Config) Print(w io.Writer) error {
+	if cfg.Redis.Password != "" {
+		cfg.Redis.Password = "***"
+	}
+	if cfg.Admin.Token != "" {
+		cfg.Admin.Token = "***"
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
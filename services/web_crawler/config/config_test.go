@@ -0,0 +1,211 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFile = `
+redis:
+  host: redis.internal
+  password: secret
+crawler:
+  workers: 12
+  fetch_timeout: 20s
+admin:
+  token: hunter2
+scope:
+  seeds:
+    - https://osu.ppy.sh/
+  max_depth: 3
+  traps:
+    template_block_urls: 8000
+    hosts:
+      - host: "*.wikipedia.org"
+        template_block_urls: -1
+`
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "crawler.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeConfig(t, testFile)
+
+	cfg, printConfig, err := Load([]string{"--config", path}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printConfig {
+		t.Error("print-config was not passed")
+	}
+
+	if cfg.Redis.Addr() != "redis.internal:6379" {
+		t.Errorf("expected redis.internal:6379 got %v", cfg.Redis.Addr())
+	}
+	if cfg.Crawler.Workers != 12 {
+		t.Errorf("expected 12 workers got %d", cfg.Crawler.Workers)
+	}
+	if cfg.Crawler.FetchTimeout != 20*time.Second {
+		t.Errorf("expected fetch timeout of 20s got %v", cfg.Crawler.FetchTimeout)
+	}
+	//unset fields keep their defaults
+	if cfg.Crawler.RobotsTimeout != Default().Crawler.RobotsTimeout {
+		t.Errorf("expected default robots timeout got %v", cfg.Crawler.RobotsTimeout)
+	}
+	if cfg.Scope.MaxDepth != 3 || len(cfg.Scope.Seeds) != 1 {
+		t.Errorf("scope not read: %+v", cfg.Scope)
+	}
+	traps := cfg.Scope.Traps
+	if traps.TemplateBlockUrls != 8000 || traps.TemplateThrottleUrls != Default().Scope.Traps.TemplateThrottleUrls {
+		t.Errorf("trap limits not read: %+v", traps.TrapLimits)
+	}
+	if len(traps.Hosts) != 1 || traps.Hosts[0].Host != "*.wikipedia.org" || traps.Hosts[0].TemplateBlockUrls != -1 {
+		t.Errorf("trap limits per host not read: %+v", traps.Hosts)
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	path := writeConfig(t, testFile)
+	vars := map[string]string{
+		"CONFIG_FILE":   path,
+		"WORKERS":       "20",
+		"FETCH_TIMEOUT": "5s",
+		"USER_AGENT":    "EnvBot/2.0",
+	}
+
+	cfg, _, err := Load([]string{"--workers", "30", "--print-config"}, env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Redis.Host != "redis.internal" {
+		t.Errorf("expected the file's redis host got %v", cfg.Redis.Host)
+	}
+	if cfg.Crawler.FetchTimeout != 5*time.Second {
+		t.Errorf("expected env to override the file, got %v", cfg.Crawler.FetchTimeout)
+	}
+	if cfg.Crawler.UserAgent != "EnvBot/2.0" {
+		t.Errorf("expected env user agent got %v", cfg.Crawler.UserAgent)
+	}
+	if cfg.Crawler.Workers != 30 {
+		t.Errorf("expected flag to override env, got %d workers", cfg.Crawler.Workers)
+	}
+
+	_, printConfig, _ := Load([]string{"--print-config"}, env(vars))
+	if !printConfig {
+		t.Error("expected print-config to be set")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	if _, _, err := Load([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}, env(nil)); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+
+	unknown := writeConfig(t, "crawler:\n  wokers: 3\n")
+	if _, _, err := Load([]string{"--config", unknown}, env(nil)); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+
+	path := writeConfig(t, testFile)
+	if _, _, err := Load([]string{"--config", path}, env(map[string]string{"REDIS_PORT": "abc"})); err == nil {
+		t.Error("expected an error for a non numeric REDIS_PORT")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Crawler.Workers = MaxWorkers + 1
+	cfg.Crawler.FetchTimeout = 0
+	cfg.Scope.Exclude = []string{"("}
+	cfg.PageStore.Kind = "s3"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected the config to be invalid")
+	}
+
+	//every problem is reported at once
+	for _, field := range []string{"crawler.workers", "crawler.fetch_timeout", "scope.seeds", "scope:", "page_store.kind"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("expected an error about %v in %v", field, err)
+		}
+	}
+
+	cfg = Default()
+	cfg.Scope.Seeds = []string{"https://osu.ppy.sh/"}
+	if err = cfg.Validate(); err != nil {
+		t.Errorf("expected a valid config got %v", err)
+	}
+
+	cfg.Scope.Deny = []string{"*.ppy.sh"}
+	if err = cfg.Validate(); err == nil || !strings.Contains(err.Error(), "out of scope") {
+		t.Errorf("expected a seed out of scope to be invalid got %v", err)
+	}
+
+	//reindexing doesn't crawl but needs somewhere to read pages from
+	cfg = Default()
+	cfg.Reindex = true
+	cfg.PageStore = PageStore{Kind: "file", Dir: t.TempDir()}
+	if err = cfg.Validate(); err != nil {
+		t.Errorf("expected reindex without seeds to be valid got %v", err)
+	}
+	cfg.PageStore.Kind = "none"
+	if err = cfg.Validate(); err == nil {
+		t.Error("expected reindex without a page store to be invalid")
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cfg := Default()
+	cfg.Redis.Password = "secret"
+	cfg.Admin.Token = "hunter2"
+
+	var b bytes.Buffer
+	if err := cfg.Print(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(b.String(), "secret") || strings.Contains(b.String(), "hunter2") {
+		t.Errorf("secrets were printed:\n%v", b.String())
+	}
+	if !strings.Contains(b.String(), "fetch_timeout: 15s") {
+		t.Errorf("expected durations to be printed readable:\n%v", b.String())
+	}
+	if cfg.Redis.Password != "secret" {
+		t.Error("Print changed the config")
+	}
+}
+
+func TestAdminExposed(t *testing.T) {
+	expected := map[string]bool{
+		"localhost:8081": false,
+		"127.0.0.1:8081": false,
+		"[::1]:8081":     false,
+		":8081":          true,
+		"0.0.0.0:8081":   true,
+		"10.0.0.5:8081":  true,
+	}
+
+	for addr, exposed := range expected {
+		if got := (Admin{Addr: addr}).Exposed(); got != exposed {
+			t.Errorf("%v: expected %v got %v", addr, exposed, got)
+		}
+	}
+}
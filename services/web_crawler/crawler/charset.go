@@ -0,0 +1,29 @@
+package crawler
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// decodeBody transcodes a response body to utf-8. The encoding comes from a
+// BOM, the charset in the Content-Type header or a <meta charset> in the
+// first 1024 bytes, in that order.
+func decodeBody(body []byte, contentType string) (string, error) {
+	enc, name, certain := charset.DetermineEncoding(body, contentType)
+
+	//without a BOM or header the guess only looked at the first 1024 bytes,
+	//a page that is valid utf-8 all the way through is almost never anything else
+	if name == "utf-8" || (!certain && utf8.Valid(body)) {
+		return strings.ToValidUTF8(string(body), "�"), nil
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return "", fmt.Errorf("could not decode body from %v %v", name, err)
+	}
+
+	return string(decoded), nil
+}
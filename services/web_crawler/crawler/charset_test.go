@@ -0,0 +1,43 @@
+package crawler
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	tests := []struct {
+		file        string
+		contentType string
+		expected    string
+	}{
+		//declared in <meta charset>
+		{"shift_jis.html", "text/html", "音楽ゲーム"},
+		//declared only in the header
+		{"windows-1252.html", "text/html; charset=windows-1252", "Le café « naïve » coûte 3 €"},
+		//declared in <meta http-equiv>
+		{"iso-8859-5.html", "", "Осу это ритм игра"},
+		//only the byte order mark tells
+		{"utf-16le-bom.html", "text/html", "Straße und Größe"},
+		//undeclared utf-8 with the first non-ascii byte past the prescan window
+		{"utf-8-late.html", "text/html", "naïve façade"},
+	}
+
+	for _, test := range tests {
+		body, err := os.ReadFile("testdata/" + test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := decodeBody(body, test.contentType)
+		if err != nil {
+			t.Errorf("%v: %v", test.file, err)
+			continue
+		}
+
+		if !strings.Contains(content, test.expected) {
+			t.Errorf("%v: expected %q in %q", test.file, test.expected, content)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package crawler
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var htmlContentTypes = []string{"text/html", "application/xhtml+xml"}
+
+// contentType returns the Content-Type of a response, sniffed from the
+// content if the server didn't send one or sent one that can't be parsed
+func contentType(header http.Header, content string) string {
+	if value := header.Get("Content-Type"); validContentType(value) {
+		return value
+	}
+	return sniffContentType([]byte(content))
+}
+
+func validContentType(contentType string) bool {
+	_, _, err := mime.ParseMediaType(contentType)
+	return err == nil
+}
+
+func sniffContentType(body []byte) string {
+	return http.DetectContentType(body[:min(len(body), 512)])
+}
+
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && slices.Contains(htmlContentTypes, mediaType)
+}
+
+// decodeContent transcodes text to utf-8, other documents like pdfs are
+// binary and kept as they were served
+func decodeContent(body []byte, contentType string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && !isHTML(contentType) && !strings.HasPrefix(mediaType, "text/") {
+		return string(body), nil
+	}
+	return decodeBody(body, contentType)
+}
+
+// parseContent parses html content, other documents return a nil node
+// and are indexed by their parser.Extractor
+func parseContent(header http.Header, content string) (*html.Node, error) {
+	if !isHTML(contentType(header, content)) {
+		return nil, nil
+	}
+
+	node, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse response body %v", err)
+	}
+	return node, nil
+}
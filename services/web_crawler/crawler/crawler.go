@@ -1,241 +1,519 @@
 package crawler
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"time"
 	"utils"
 	"web_crawler/database"
 	"web_crawler/handlers"
-	"web_crawler/parser"
+	"web_crawler/metrics"
 	"web_crawler/types"
-	"web_crawler/utilities"
 
 	"golang.org/x/net/html"
 )
 
-// returns html as node
-func Crawl(normUrl string) (*html.Node, string, error) {
-	userAgent := os.Getenv("USER_AGENT")
+// how many redirects fetch follows before giving up on a url
+const maxRedirects = 10
+
+// fetches a url once, errors are *FetchError or *SkipError.
+// the validators of the last fetch make the request conditional,
+// ErrNotModified is returned if the server says nothing changed.
+func fetch(normUrl string, last types.FetchState) (types.Response, error) {
+	userAgent := handlers.UserAgent
+
+	response := types.Response{Url: normUrl}
 
 	req, err := http.NewRequest("GET", normUrl, nil)
 	if err != nil {
-		return nil, "", fmt.Errorf("error from http request to %v %v", normUrl, err)
+		return response, &FetchError{Class: FetchPermanent, Err: fmt.Errorf("error from http request to %v %v", normUrl, err)}
 	}
 
 	req.Header.Set("User-Agent", userAgent)
+	if last.ETag != "" {
+		req.Header.Set("If-None-Match", last.ETag)
+	}
+	if last.LastModified != "" {
+		req.Header.Set("If-Modified-Since", last.LastModified)
+	}
+
+	client := &http.Client{
+		Timeout: FetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errTooManyRedirects
+			}
+			response.Redirects = append(response.Redirects, req.URL.String())
+			return nil
+		},
+	}
+	start := time.Now()
+	defer func() {
+		metrics.FetchDuration.Observe(time.Since(start).Seconds())
+	}()
 
-	client := &http.Client{Timeout: 15 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, "", fmt.Errorf("could not get url: %v %v", normUrl, err)
+		return response, classifyError(fmt.Errorf("could not get url: %v %w", normUrl, err))
 	}
 	defer resp.Body.Close()
+	metrics.ObserveResponse(resp.StatusCode)
+
+	response.FinalUrl = resp.Request.URL.String()
+	response.StatusCode = resp.StatusCode
+	response.Header = resp.Header
+
+	if resp.StatusCode == http.StatusNotModified {
+		return response, ErrNotModified
+	}
+
+	if fetchErr := classifyStatus(resp.StatusCode, resp.Header); fetchErr != nil {
+		return response, fetchErr
+	}
+
+	//a missing or garbled content-type is sniffed from the body once it is read
+	contentType := resp.Header.Get("Content-Type")
+	if skipErr := checkContentType(contentType); skipErr != nil {
+		return response, skipErr
+	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	if resp.ContentLength > MaxBodySize {
+		return response, &SkipError{Reason: SkipTooLarge, Detail: fmt.Sprintf("content-length %d", resp.ContentLength)}
+	}
+
+	//read one byte past the limit to tell a body of exactly MaxBodySize from a larger one
+	bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, MaxBodySize+1))
 	if err != nil {
-		return nil, "", fmt.Errorf("could not read body %v", err)
+		return response, classifyError(fmt.Errorf("could not read body %w", err))
+	}
+	metrics.BytesDownloaded.Add(float64(len(bodyBytes)))
+	if int64(len(bodyBytes)) > MaxBodySize {
+		return response, &SkipError{Reason: SkipTooLarge, Detail: fmt.Sprintf("body over %d bytes", MaxBodySize)}
 	}
 
-	html, err := html.Parse(bytes.NewReader(bodyBytes))
+	if !validContentType(contentType) {
+		contentType = sniffContentType(bodyBytes)
+		if skipErr := checkContentType(contentType); skipErr != nil {
+			return response, skipErr
+		}
+	}
+
+	if Archive != nil {
+		if err = Archive.WriteExchange(resp, bodyBytes); err != nil {
+			log.Println(err)
+		}
+	}
+
+	response.Content, err = decodeContent(bodyBytes, contentType)
 	if err != nil {
-		return nil, "", fmt.Errorf("could not parse response body %v", err)
+		return response, &FetchError{Class: FetchPermanent, StatusCode: resp.StatusCode, Err: err}
 	}
+	metrics.PagesFetched.Inc()
 
-	content := string(bodyBytes)
+	return response, nil
+}
 
-	return html, content, nil
+// returns html as node, nil for documents that aren't html.
+// transient errors are retried with backoff
+func Crawl(normUrl string) (*html.Node, types.Response, error) {
+	return CrawlIfChanged(normUrl, types.FetchState{})
 }
 
-func CrawlJob(db *database.DataBase) {
+// like Crawl but sends the validators from the last fetch along
+func CrawlIfChanged(normUrl string, last types.FetchState) (*html.Node, types.Response, error) {
+	var response types.Response
+	var err error
+
+	for attempt := range maxFetchAttempts {
+		response, err = fetch(normUrl, last)
+		if err == nil {
+			break
+		}
+
+		var fetchErr *FetchError
+		if !errors.As(err, &fetchErr) || fetchErr.Class == FetchPermanent || attempt == maxFetchAttempts-1 {
+			return nil, response, err
+		}
+
+		wait := backoff(attempt, fetchErr.RetryAfter)
+		if wait > maxBackoff {
+			//too long to hold a worker, CrawlJob reschedules the host instead
+			return nil, response, err
+		}
+		log.Printf("%v, retrying %v in %v\n", err, normUrl, wait)
+		time.Sleep(wait)
+	}
+
+	html, err := parseContent(response.Header, response.Content)
+	if err != nil {
+		return nil, response, err
+	}
+
+	return html, response, nil
+}
+
+// returned by crawlLink when the url has to wait for its domain's crawl delay
+var errCrawlDelay = errors.New("crawl delay has not passed")
+
+// returned by crawlLink when the domain's robots.txt couldn't be fetched
+var errRobotsUnavailable = errors.New("robots.txt unavailable")
+
+func CrawlJob(db *database.DataBase, workerID string) {
 
 	start := time.Now()
 
-	//get url from database
-	link, err := db.PopUrl()
+	//lease url from a host that is ready to be crawled
+	link, err := db.PopUrl(workerID)
 	if err != nil {
 		log.Printf("could not pop url from db %v\n", err)
 		return
 	}
+	if link == "" {
+		metrics.SetWorkerState(workerID, metrics.WorkerIdle)
+		waitForReadyHost(db)
+		return
+	}
+	metrics.SetWorkerState(workerID, metrics.WorkerCrawling)
+	defer metrics.SetWorkerState(workerID, metrics.WorkerIdle)
+
+	err = crawlLink(db, link)
+	var fetchErr *FetchError
+	var skipErr *SkipError
+	var scopeErr *database.ScopeError
+	switch {
+	case errors.As(err, &scopeErr):
+		//the scope changed or its template turned out to be a spider trap since the url was queued
+		log.Printf("Skipping url: %v %v\n", link, scopeErr)
+		if err = db.AckUrl(workerID, link); err != nil {
+			log.Println(err)
+		}
+		return
+	case errors.As(err, &skipErr):
+		log.Printf("Skipping url: %v %v\n", link, skipErr)
+		if err = db.AddSkippedUrl(link, string(skipErr.Reason), skipErr.Detail); err != nil {
+			log.Println(err)
+		}
+		if err = db.AckUrl(workerID, link); err != nil {
+			log.Println(err)
+		}
+		return
+	case errors.Is(err, errCrawlDelay), errors.Is(err, errRobotsUnavailable):
+		if err = db.ReleaseUrl(workerID, link); err != nil {
+			log.Printf("could not release url. Reason: %v\n", err)
+		}
+		return
+	case errors.As(err, &fetchErr) && fetchErr.Class == FetchRateLimited:
+		//the host asked us to slow down, back off the whole host and try the url again later
+		log.Printf("rate limited on url: %v, retrying after %v\n", link, fetchErr.RetryAfter)
+		//the retries are used up, without a Retry-After the host backs off like another attempt would
+		if err = db.ScheduleHost(hostOf(link), time.Now().Add(backoff(maxFetchAttempts, fetchErr.RetryAfter))); err != nil {
+			log.Println(err)
+		}
+		if err = db.ReleaseUrl(workerID, link); err != nil {
+			log.Printf("could not release url. Reason: %v\n", err)
+		}
+		return
+	case errors.As(err, &fetchErr) && fetchErr.Class == FetchPermanent:
+		//retrying won't change anything
+		log.Printf("Could not crawl url: %v %v\n", link, err)
+		if err = db.AddRecentError(link, err); err != nil {
+			log.Println(err)
+		}
+		if err = db.AckUrl(workerID, link); err != nil {
+			log.Println(err)
+		}
+		return
+	case err != nil:
+		log.Printf("Could not crawl url: %v %v\n", link, err)
+		if recordErr := db.AddRecentError(link, err); recordErr != nil {
+			log.Println(recordErr)
+		}
+		deadLettered, err := db.FailUrl(link, err.Error())
+		if err != nil {
+			log.Println(err)
+		}
+		if deadLettered {
+			log.Printf("url: %v ran out of retries and was dead-lettered\n", link)
+		}
+		return
+	}
+
+	if err = db.AckUrl(workerID, link); err != nil {
+		log.Println(err)
+	}
+
+	end := time.Now()
 
+	log.Printf("time taken ms: %v", end.UnixMilli()-start.UnixMilli())
+}
+
+// crawls a leased url and stores everything found on the page.
+// a nil error means the url is done with, even if it was disallowed.
+func crawlLink(db *database.DataBase, link string) error {
 	u, err := url.Parse(link)
 	if err != nil {
-		log.Printf("could not parse url: %v %v\n", link, err)
-		return
+		return fmt.Errorf("could not parse url: %v %v", link, err)
+	}
+
+	depth, err := db.GetDepth(link)
+	if err != nil {
+		return err
+	}
+	if err = db.CheckScope(link, depth); err != nil {
+		return err
+	}
+	//the template may have been blocked while the url was queued
+	if err = db.CheckTraps(link); err != nil {
+		return err
 	}
 
 	//check if domain exists and create a new one if not
 	domainExists, err := db.DomainExists(u.Host)
 	if err != nil {
-		log.Printf("couldn not check if domain: %v exists %v\n", u.Host, err)
-		return
+		return fmt.Errorf("could not check if domain: %v exists %v", u.Host, err)
 	}
 
 	var domain types.Domain
 	if domainExists {
 		domain, err = db.GetDomain(u.Host)
 		if err != nil {
-			log.Printf("could not get domain: %v %v\n", u.Host, err)
-			return
+			return fmt.Errorf("could not get domain: %v %v", u.Host, err)
 		}
-	} else {
+	}
+
+	//robots.txt is cached on the domain until it expires
+	if !domainExists || domain.RobotsExpires <= time.Now().Unix() {
+		lastCrawled := domain.LastCrawled
+
 		domain, err = handlers.GetRobotsFromDomain(u.Scheme + "://" + u.Host)
+		if domain.Name == "" {
+			return fmt.Errorf("could not get new domain: %v reason: %v", u.Scheme+"://"+u.Host, err)
+		}
 		if err != nil {
-			err = db.AddDomain(domain)
-			if err != nil {
-				log.Printf("bruh moment %v\n", err)
-			}
-			log.Printf("could not get new domain: %v reason: %v", u.Scheme+"://"+u.Host, err)
-			return
+			//the domain is still stored, it disallows everything until robots.txt can be fetched
+			log.Printf("could not get robots for domain: %v reason: %v", u.Host, err)
+		}
+
+		//a refresh must not reset when the domain was last crawled
+		if domainExists {
+			domain.LastCrawled = lastCrawled
 		}
 
 		err = db.AddDomain(domain)
 		if err != nil {
-			log.Printf("could not add domain: %v to db. Reason: %v", u.Host, err)
-			return
+			return fmt.Errorf("could not add domain: %v to db. Reason: %v", u.Host, err)
+		}
+
+		//read in the background, fetching them would hold the url past its lease.
+		//every refresh of robots.txt reads them again to pick up new lastmods
+		if len(domain.Sitemaps) > 0 {
+			if err = db.QueueSitemaps(domain.Name, depth+1); err != nil {
+				log.Println(err)
+			}
 		}
 	}
 
 	//check domain and if we are allowed to crawl else return
-	//and put url back in the queue
 	canCrawl, reason, err := handlers.CanCrawl(link, domain)
 	if err != nil {
-		log.Printf("error from CanCrawl function %v\n", err)
-		return
+		return fmt.Errorf("error from CanCrawl function %v", err)
 	}
 	if !canCrawl {
-		if reason == handlers.ReasonCrawlDelay {
-			//remove link from set and put back in queue
-			err = db.RemoveUrlFromSet(link)
+		metrics.RobotsDenied.WithLabelValues(reason.String()).Inc()
+		switch reason {
+		case handlers.ReasonCrawlDelay:
+			//the frontier reserved the host with the default delay before
+			//robots.txt was known, wait out the real one
+			err = db.ScheduleHost(domain.Name, time.Unix(domain.LastCrawled+domain.CrawlDelay, 0))
 			if err != nil {
-				log.Printf("%v\n", err)
+				log.Println(err)
 			}
-			err = db.PushUrl(link)
+			return errCrawlDelay
+		case handlers.ReasonRobotsUnavailable:
+			//park the host until robots.txt is due to be fetched again
+			err = db.ScheduleHost(domain.Name, time.Unix(domain.RobotsExpires, 0))
 			if err != nil {
-				log.Printf("could not push url to queue. Reason: %v\n", err)
+				log.Println(err)
 			}
+			return errRobotsUnavailable
 		}
-		return
+		return nil
+	}
+
+	lastCrawled := utils.GetTimeInt()
+	err = db.UpdateDomainLastCrawled(domain.Name, lastCrawled)
+	if err != nil {
+		log.Println(err)
 	}
 
-	err = db.UpdateDomainLastCrawled(domain.Name, utils.GetTimeInt())
+	err = db.ScheduleHost(domain.Name, time.Unix(lastCrawled+domain.CrawlDelay, 0))
 	if err != nil {
 		log.Println(err)
 	}
 
 	log.Printf("Crawling: %v", link)
 
-	//crawl it
-	html, content, err := Crawl(link)
+	lastFetch, err := db.GetFetchState(link)
 	if err != nil {
-		log.Printf("Could not crawl url: %v %v\n", link, err)
-		return
+		return err
 	}
 
-	//normalize urls and put new urls in database
-	title, rawUrls, images, wordMap := parser.ParseBody(link, html)
-	newUrls, err := utilities.NormalizeUrlSlice(link, rawUrls)
+	//crawl it
+	html, response, err := CrawlIfChanged(link, lastFetch)
+	if errors.Is(err, ErrNotModified) {
+		return db.SetFetchState(updateFetchState(lastFetch, response, false, utils.GetTimeInt()))
+	}
 	if err != nil {
-		log.Println(err)
-		return
+		if response.StatusCode != 0 {
+			if statusErr := db.SetFetchStatus(link, response.StatusCode); statusErr != nil {
+				log.Println(statusErr)
+			}
+		}
+		//a page that was indexed and is gone now must not stay in the index
+		var fetchErr *FetchError
+		if errors.As(err, &fetchErr) && fetchErr.Class == FetchPermanent && lastFetch.LastFetched != 0 {
+			log.Printf("page gone, removing it from the index: %v", link)
+			if removeErr := removeIndexedPage(db, link, lastFetch); removeErr != nil {
+				log.Println(removeErr)
+			}
+			//if the page comes back it is indexed again even with the same content
+			if forgetErr := db.ForgetFetchedContent(link); forgetErr != nil {
+				log.Println(forgetErr)
+			}
+		}
+		return err
 	}
 
-	page := types.Page{
-		NormUrl:  link,
-		Content:  content,
-		OutLinks: newUrls,
+	return storePage(db, link, lastFetch, depth, html, response, false, utils.GetTimeInt())
+}
+
+// storePage indexes a fetched page unless it didn't change since lastFetch,
+// reindex indexes it even then. fetched is when the page was fetched in unix seconds.
+func storePage(db *database.DataBase, link string, lastFetch types.FetchState, depth int, html *html.Node, response types.Response, reindex bool, fetched int64) error {
+	//the page is stored under its canonical url, the requested url and
+	//every url it redirected through become aliases of it
+	canonical := canonicalUrl(response.FinalUrl, html)
+	redirects := redirectChain(response.Redirects)
+	if len(redirects) > 0 {
+		if err := db.AddRedirectChain(link, redirects); err != nil {
+			log.Println(err)
+		}
 	}
-	err = db.AddPage(page)
-	if err != nil {
-		log.Printf("page: %v could not be added to database %v\n", link, err)
+	aliases := append([]string{link}, redirects...)
+	if err := db.AddAliases(canonical, aliases); err != nil {
+		log.Println(err)
 	}
 
-	for _, newUrl := range newUrls {
-		//log.Printf("attempting to add url: %v to queue\n", newUrl)
-		err = db.PushUrl(newUrl)
+	//another url was already indexed under this canonical, this one is only an alias
+	if canonical != link && canonical != lastFetch.Canonical {
+		exists, err := db.DocumentExists(canonical)
 		if err != nil {
-			log.Printf("Could not add url: %v to queue %v\n", newUrl, err)
+			return err
+		}
+		if exists {
+			log.Printf("url: %v is an alias of %v\n", link, canonical)
+			metrics.DedupHits.WithLabelValues(metrics.DedupAlias).Inc()
+			return nil
 		}
 	}
 
-	//add image indices
-	imageIndex := types.ImageIndex{}
-	for _, image := range images {
-		m := utilities.IndexImage(image)
-
-		for term, frequency := range m {
-			imageUrl, err := utilities.NormalizeLink(link, image.ImageUrl)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
+	//servers without validators still send the same page back
+	contentHash := database.ContentHash(response.Content)
+	changed := contentHash != lastFetch.ContentHash
+	if !changed && !reindex {
+		return db.SetFetchState(updateFetchState(lastFetch, response, false, fetched))
+	}
 
-			imageIndex[term] = append(imageIndex[term], types.ImagePosting{
-				ImageUrl:      imageUrl,
-				TermFrequency: frequency,
-			})
+	//the page changed since the last crawl, drop what that crawl indexed
+	if lastFetch.LastFetched != 0 {
+		if changed {
+			log.Printf("page changed, reindexing: %v", link)
+		}
+		//stored again if the new content gets indexed
+		if err := removeIndexedPage(db, link, lastFetch); err != nil {
+			return err
 		}
 	}
 
-	if err = db.AddImageIndex(imageIndex); err != nil {
+	fetchState := updateFetchState(lastFetch, response, changed, fetched)
+	fetchState.ContentHash = contentHash
+	fetchState.Canonical = canonical
+	if err := db.SetFetchState(fetchState); err != nil {
 		log.Println(err)
 	}
 
-	//add document
-	document := types.Document{
-		NormUrl: link,
-		Length:  len(wordMap),
-		Title:   title,
+	return indexPage(db, response.FinalUrl, canonical, aliases, depth, html, response, fetched)
+}
+
+// removeIndexedPage drops everything the last crawl of link indexed and the
+// raw page it stored, under the url the page was indexed as
+func removeIndexedPage(db *database.DataBase, link string, lastFetch types.FetchState) error {
+	previous := lastFetch.Canonical
+	if previous == "" {
+		previous = link
 	}
-	err = db.AddDocument(document)
-	if err != nil {
-		log.Println(err)
+	if err := db.RemovePagePostings(previous, lastFetch.ContentHash); err != nil {
+		return err
 	}
-
-	//add wordmap/index
-	index := types.InvertedIndex{}
-	for word, score := range wordMap {
-		index[word] = types.Posting{
-			TermFrequency: score,
-			NormUrl:       link,
+	if Pages != nil {
+		if err := Pages.Delete(previous); err != nil {
+			log.Println(err)
 		}
 	}
+	return nil
+}
 
-	err = db.AddIndex(index)
+func hostOf(link string) string {
+	u, err := url.Parse(link)
 	if err != nil {
-		log.Println(err)
+		return ""
 	}
-
-	end := time.Now()
-
-	log.Printf("time taken ms: %v", end.UnixMilli()-start.UnixMilli())
+	return u.Host
 }
 
-func Start(ctx context.Context, db *database.DataBase) {
-	qlen, err := db.UrlQueueLength()
+// longest a worker sleeps before asking the frontier again
+const maxIdleWait = 1 * time.Second
+
+// sleeps until the next host in the frontier is ready
+func waitForReadyHost(db *database.DataBase) {
+	wait, err := db.NextReadyIn()
 	if err != nil {
-		panic(err)
+		log.Println(err)
+		wait = maxIdleWait
+	}
+	//nothing is waiting on a crawl delay, only leased urls or recrawls can bring new work
+	if wait == 0 {
+		wait = maxIdleWait
 	}
-	for qlen > 0 {
+	time.Sleep(min(wait, maxIdleWait))
+}
+
+// how often expired leases are put back in the frontier
+const reapInterval = 30 * time.Second
+
+// StartReaper requeues urls whose worker died or hung until ctx is done
+func StartReaper(ctx context.Context, db *database.DataBase) {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+
+	for {
 		select {
 		case <-ctx.Done():
-			fmt.Println("Crawler stopping")
 			return
-		default:
-			CrawlJob(db)
-		}
-		qlen, err = db.UrlQueueLength()
-		if err != nil {
-			panic(err)
+		case <-ticker.C:
+			reaped, err := db.ReapLeases()
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if reaped > 0 {
+				log.Printf("reaped %d expired leases\n", reaped)
+			}
 		}
 	}
-	if qlen == 0 {
-		fmt.Println("queue is empty")
-	}
 }
@@ -18,10 +18,7 @@ func TestCrawlAndParse(t *testing.T) {
 	_, urls, _, _ := parser.ParseBody(url, html)
 
 	fmt.Println(len(urls))
-	normUrls, err := utilities.NormalizeUrlSlice(url, urls)
-	if err != nil {
-		t.Error(err)
-	}
+	normUrls := utilities.NormalizeUrlSlice(url, urls)
 	fmt.Println(len(normUrls))
 }
 
@@ -29,10 +26,10 @@ func TestCrawlJob(t *testing.T) {
 	db := database.DataBase{}
 	db.Connect("localhost:6379", "0", "")
 
-	err := db.PushUrl("https://en.wikipedia.org/wiki/Osu!")
+	err := db.PushUrl("https://en.wikipedia.org/wiki/Osu!", 0)
 	if err != nil {
 		t.Error(err)
 	}
 
-	CrawlJob(&db)
+	CrawlJob(&db, "test")
 }
@@ -0,0 +1,198 @@
+package crawler
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"web_crawler/parser"
+	"web_crawler/warc"
+)
+
+type FetchClass int
+
+const (
+	// retrying won't help: 404, 410, dns name not found, bad certificate...
+	FetchPermanent FetchClass = iota
+	// timeouts, connection resets and 5xx, worth retrying after a while
+	FetchTransient
+	// 429 or 503 with Retry-After, the whole host should back off
+	FetchRateLimited
+)
+
+func (c FetchClass) String() string {
+	switch c {
+	case FetchPermanent:
+		return "permanent"
+	case FetchTransient:
+		return "transient"
+	case FetchRateLimited:
+		return "rate-limited"
+	}
+	return "unknown"
+}
+
+// FetchError is returned by Crawl when a url could not be fetched
+type FetchError struct {
+	Class      FetchClass
+	StatusCode int
+	// zero unless the server sent a Retry-After header
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%v fetch error, status %d: %v", e.Class, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("%v fetch error: %v", e.Class, e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// returned by Crawl when a conditional request got a 304 back
+var ErrNotModified = errors.New("not modified")
+
+// a redirect chain longer than maxRedirects, most likely a loop
+var errTooManyRedirects = errors.New("too many redirects")
+
+type SkipReason string
+
+const (
+	SkipContentType SkipReason = "content-type"
+	SkipTooLarge    SkipReason = "too-large"
+)
+
+// SkipError is returned by Crawl when a response was fetched fine but is
+// nothing the crawler can index
+type SkipError struct {
+	Reason SkipReason
+	Detail string
+}
+
+func (e *SkipError) Error() string {
+	return fmt.Sprintf("skipped (%v): %v", e.Reason, e.Detail)
+}
+
+// bodies larger than this are not downloaded, set from the config in main
+var MaxBodySize int64 = 5 << 20
+
+// how long a single fetch may take, set from the config in main
+var FetchTimeout = 15 * time.Second
+
+// downloaded pages are archived to it unless it is nil, set from the config in main
+var Archive *warc.Writer
+
+// html and documents with a parser.Extractor are crawled. a missing or
+// unparsable content-type is let through, the body is sniffed after reading
+func checkContentType(contentType string) *SkipError {
+	if contentType == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+
+	if isHTML(mediaType) {
+		return nil
+	}
+	if _, ok := parser.ExtractorFor(mediaType); ok {
+		return nil
+	}
+
+	return &SkipError{Reason: SkipContentType, Detail: mediaType}
+}
+
+const maxFetchAttempts = 3
+const baseBackoff = 1 * time.Second
+
+// a Retry-After longer than this isn't waited out by the worker,
+// the host gets rescheduled instead
+const maxBackoff = 30 * time.Second
+
+func classifyStatus(statusCode int, header http.Header) *FetchError {
+	if statusCode >= 200 && statusCode < 300 {
+		return nil
+	}
+
+	fetchErr := &FetchError{
+		Class:      FetchPermanent,
+		StatusCode: statusCode,
+		RetryAfter: parseRetryAfter(header.Get("Retry-After")),
+		Err:        errors.New(http.StatusText(statusCode)),
+	}
+
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		fetchErr.Class = FetchRateLimited
+	case statusCode == http.StatusServiceUnavailable && fetchErr.RetryAfter > 0:
+		fetchErr.Class = FetchRateLimited
+	case statusCode == http.StatusRequestTimeout,
+		statusCode >= 500 && statusCode != http.StatusNotImplemented:
+		fetchErr.Class = FetchTransient
+	}
+
+	return fetchErr
+}
+
+func classifyError(err error) *FetchError {
+	fetchErr := &FetchError{Class: FetchTransient, Err: err}
+
+	var dnsErr *net.DNSError
+	var certErr *x509.CertificateInvalidError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+
+	switch {
+	case errors.Is(err, errTooManyRedirects):
+		fetchErr.Class = FetchPermanent
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsNotFound {
+			fetchErr.Class = FetchPermanent
+		}
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthErr), errors.As(err, &hostnameErr):
+		fetchErr.Class = FetchPermanent
+	}
+
+	return fetchErr
+}
+
+// Retry-After is either a number of seconds or an http date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+
+	return 0
+}
+
+// exponential backoff with jitter, Retry-After wins if the server sent one
+func backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+
+	wait := baseBackoff << attempt
+	jitter := time.Duration(rand.Int63n(int64(wait) / 2))
+	return min(wait+jitter, maxBackoff)
+}
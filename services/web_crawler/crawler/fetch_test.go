@@ -0,0 +1,186 @@
+package crawler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	expected := map[int]FetchClass{
+		http.StatusNotFound:            FetchPermanent,
+		http.StatusGone:                FetchPermanent,
+		http.StatusForbidden:           FetchPermanent,
+		http.StatusRequestTimeout:      FetchTransient,
+		http.StatusInternalServerError: FetchTransient,
+		http.StatusBadGateway:          FetchTransient,
+		http.StatusServiceUnavailable:  FetchTransient,
+		http.StatusTooManyRequests:     FetchRateLimited,
+	}
+
+	for status, class := range expected {
+		fetchErr := classifyStatus(status, http.Header{})
+		if fetchErr == nil || fetchErr.Class != class {
+			t.Errorf("status %d expected %v got %v", status, class, fetchErr)
+		}
+	}
+
+	if fetchErr := classifyStatus(http.StatusOK, http.Header{}); fetchErr != nil {
+		t.Errorf("expected no error for 200 got %v", fetchErr)
+	}
+
+	header := http.Header{}
+	header.Set("Retry-After", "120")
+	fetchErr := classifyStatus(http.StatusServiceUnavailable, header)
+	if fetchErr.Class != FetchRateLimited || fetchErr.RetryAfter != 2*time.Minute {
+		t.Errorf("expected rate limited for 2m got %v %v", fetchErr.Class, fetchErr.RetryAfter)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("5"); d != 5*time.Second {
+		t.Errorf("expected 5s got %v", d)
+	}
+
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if d := parseRetryAfter(date); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("expected about an hour got %v", d)
+	}
+
+	if d := parseRetryAfter("soon"); d != 0 {
+		t.Errorf("expected 0 got %v", d)
+	}
+}
+
+func TestCrawlRetriesTransient(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("<html><head><title>ok</title></head></html>"))
+	}))
+	defer server.Close()
+
+	_, response, err := Crawl(server.URL)
+	if err != nil {
+		t.Error(err)
+	}
+	if attempts != 2 || response.StatusCode != http.StatusOK {
+		t.Errorf("expected 2 attempts ending in 200 got %d attempts status %d", attempts, response.StatusCode)
+	}
+}
+
+func TestCrawlDoesNotRetryPermanent(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, response, err := Crawl(server.URL)
+
+	var fetchErr *FetchError
+	if !errors.As(err, &fetchErr) || fetchErr.Class != FetchPermanent {
+		t.Errorf("expected permanent fetch error got %v", err)
+	}
+	if attempts != 1 || response.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 1 attempt ending in 404 got %d attempts status %d", attempts, response.StatusCode)
+	}
+}
+
+func TestCrawlSkipsNonHtml(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write([]byte("PK\x03\x04"))
+	}))
+	defer server.Close()
+
+	_, _, err := Crawl(server.URL)
+
+	var skipErr *SkipError
+	if !errors.As(err, &skipErr) || skipErr.Reason != SkipContentType {
+		t.Errorf("expected content-type skip got %v", err)
+	}
+}
+
+func TestCrawlSniffsGarbledContentType(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html;;; nonsense")
+		if r.URL.Path == "/image" {
+			w.Write([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+			return
+		}
+		w.Write([]byte("<html><body>hello</body></html>"))
+	}))
+	defer server.Close()
+
+	_, _, err := Crawl(server.URL + "/image")
+	var skipErr *SkipError
+	if !errors.As(err, &skipErr) || skipErr.Reason != SkipContentType {
+		t.Errorf("expected the sniffed image to be skipped got %v", err)
+	}
+
+	node, _, err := Crawl(server.URL + "/page")
+	if err != nil || node == nil {
+		t.Errorf("expected the sniffed html to be parsed got %v %v", node, err)
+	}
+}
+
+func TestCrawlDocuments(t *testing.T) {
+	pdf := "%PDF-1.4\n\xe2\xe3\xcf\xd3\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/doc.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte(pdf))
+		default:
+			//sniffed as text/plain
+			w.Header()["Content-Type"] = nil
+			w.Write([]byte("just some text"))
+		}
+	}))
+	defer server.Close()
+
+	node, response, err := Crawl(server.URL + "/doc.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node != nil || response.Content != pdf {
+		t.Errorf("expected raw pdf and no html got %v %q", node, response.Content)
+	}
+
+	node, response, err = Crawl(server.URL + "/notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node != nil || response.Content != "just some text" {
+		t.Errorf("expected text and no html got %v %q", node, response.Content)
+	}
+}
+
+func TestCrawlSkipsLargeBody(t *testing.T) {
+	defer func(size int64) { MaxBodySize = size }(MaxBodySize)
+	MaxBodySize = 16
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		//flushing forces chunked encoding so there is no content-length to go by
+		w.Write([]byte("<html><body>"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("way more than sixteen bytes</body></html>"))
+	}))
+	defer server.Close()
+
+	_, _, err := Crawl(server.URL)
+
+	var skipErr *SkipError
+	if !errors.As(err, &skipErr) || skipErr.Reason != SkipTooLarge {
+		t.Errorf("expected too-large skip got %v", err)
+	}
+}
@@ -0,0 +1,313 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"utils"
+	"web_crawler/database"
+	"web_crawler/handlers"
+	"web_crawler/metrics"
+	"web_crawler/pagestore"
+	"web_crawler/parser"
+	"web_crawler/types"
+	"web_crawler/utilities"
+
+	"golang.org/x/net/html"
+)
+
+// canonicalUrl returns the <link rel="canonical"> of a page or pageUrl if it has none.
+// a canonical on another host is ignored, it could file the page under someone else's url.
+// body is nil for documents that aren't html.
+func canonicalUrl(pageUrl string, body *html.Node) string {
+	if normUrl, err := utilities.CanonicalizeUrl(pageUrl); err == nil && normUrl != "" {
+		pageUrl = normUrl
+	}
+	if body == nil {
+		return pageUrl
+	}
+
+	href := parser.GetCanonical(body)
+	if href == "" {
+		return pageUrl
+	}
+
+	canonical, err := utilities.NormalizeLink(pageUrl, href)
+	if err != nil || canonical == "" || hostOf(canonical) != hostOf(pageUrl) {
+		return pageUrl
+	}
+
+	return canonical
+}
+
+// canonicalizes the urls of a redirect chain, dropping the ones that can't be crawled
+func redirectChain(redirects []string) []string {
+	chain := []string{}
+	for _, redirect := range redirects {
+		normUrl, err := utilities.CanonicalizeUrl(redirect)
+		if err != nil || normUrl == "" {
+			continue
+		}
+		chain = append(chain, normUrl)
+	}
+	return chain
+}
+
+// pages with fewer terms than this are too short to be fingerprinted,
+// their fingerprints would match any other short page
+const minFingerprintTerms = 16
+
+// indexed pages are kept in it unless it is nil, set from the config in main
+var Pages pagestore.Store
+
+// what indexing takes from a page
+type parsedPage struct {
+	canonical string
+	title     string
+	outLinks  []string
+	images    []types.Image
+	terms     *types.Terms
+	//target url -> term -> links to it with the term in their text
+	anchors map[string]map[string]int
+	robots  types.RobotsDirectives
+}
+
+// relative links are resolved against pageUrl, everything is keyed by canonical.
+// html is nil for other documents, they are read from content by their parser.Extractor.
+func parsePage(pageUrl string, canonical string, html *html.Node, content string, header http.Header) (parsedPage, error) {
+	//meta robots and X-Robots-Tag can forbid indexing and following the page's links
+	botName := handlers.BotName()
+	directives := handlers.XRobotsTagValues(header, botName)
+
+	var title string
+	var rawUrls []string
+	var images []types.Image
+	var terms *types.Terms
+	rawAnchors := map[string]map[string]int{}
+	if html != nil {
+		directives = append(parser.GetMetaRobots(html, botName), directives...)
+		title, rawUrls, images, terms = parser.ParseBody(canonical, html)
+		rawAnchors = parser.GetAnchors(html, terms.Lang)
+	} else {
+		contentType := contentType(header, content)
+		extractor, ok := parser.ExtractorFor(contentType)
+		if !ok {
+			return parsedPage{}, fmt.Errorf("no extractor for %v", contentType)
+		}
+		var err error
+		title, rawUrls, images, terms, err = extractor.Extract(canonical, content)
+		if err != nil {
+			return parsedPage{}, err
+		}
+	}
+	robots := handlers.ParseRobotsDirectives(directives...)
+
+	//normalize urls
+	outLinks := utilities.NormalizeUrlSlice(pageUrl, rawUrls)
+	if robots.NoFollow {
+		outLinks = []string{}
+		rawAnchors = map[string]map[string]int{}
+	}
+
+	//links to the page itself don't describe it to anyone else
+	anchors := make(map[string]map[string]int)
+	for href, terms := range rawAnchors {
+		target, err := utilities.NormalizeLink(pageUrl, href)
+		if err != nil || target == "" || target == canonical {
+			continue
+		}
+		if anchors[target] == nil {
+			anchors[target] = make(map[string]int)
+		}
+		for term, count := range terms {
+			anchors[target][term] += count
+		}
+	}
+
+	return parsedPage{
+		canonical: canonical,
+		title:     title,
+		outLinks:  outLinks,
+		images:    images,
+		terms:     terms,
+		anchors:   anchors,
+		robots:    robots,
+	}, nil
+}
+
+// term frequencies of what the page says, its url is left out
+// so duplicates under different urls get the same fingerprint
+func (p parsedPage) contentTerms() map[string]int {
+	return p.terms.Fields.Frequencies(utils.FieldTitle, utils.FieldHeading, utils.FieldBody)
+}
+
+// writePage stores the links, anchor text, fingerprint, images, document and postings of a page.
+// noindex pages only get their links and anchor text stored. returns database.ErrDuplicateContent
+// without storing anything if a page with the same content is indexed already.
+func writePage(db *database.DataBase, page parsedPage, pageUrl string, content string, statusCode int) error {
+	err := db.AddPage(types.Page{
+		NormUrl:   page.canonical,
+		Content:   content,
+		OutLinks:  page.outLinks,
+		NoArchive: page.robots.NoArchive,
+	})
+	if errors.Is(err, database.ErrDuplicateContent) {
+		return err
+	}
+	if err != nil {
+		log.Printf("page: %v could not be added to database %v\n", page.canonical, err)
+	}
+
+	if err = db.AddAnchors(page.canonical, page.anchors); err != nil {
+		log.Println(err)
+	}
+
+	//noindex pages are crawled for their links only
+	if page.robots.NoIndex {
+		log.Printf("page: %v is noindex\n", page.canonical)
+		return nil
+	}
+
+	if terms := page.contentTerms(); len(terms) >= minFingerprintTerms {
+		if err = db.AddFingerprint(page.canonical, utilities.SimHash(terms)); err != nil {
+			log.Println(err)
+		}
+	}
+
+	//add image indices
+	imageIndex := types.ImageIndex{}
+	for _, image := range page.images {
+		m := utilities.IndexImage(image)
+
+		for term, frequency := range m {
+			imageUrl, err := utilities.NormalizeLink(pageUrl, image.ImageUrl)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			imageIndex[term] = append(imageIndex[term], types.ImagePosting{
+				ImageUrl:      imageUrl,
+				TermFrequency: frequency,
+			})
+		}
+	}
+
+	if err = db.AddImageIndex(page.canonical, imageIndex); err != nil {
+		log.Println(err)
+	}
+
+	if err = db.SetFetchStatus(page.canonical, statusCode); err != nil {
+		log.Println(err)
+	}
+
+	//add document
+	document := types.Document{
+		NormUrl: page.canonical,
+		Length:  len(page.terms.Fields),
+		Title:   page.title,
+		Lang:    page.terms.Lang,
+	}
+	err = db.AddDocument(document)
+	if err != nil {
+		log.Println(err)
+	}
+
+	//add wordmap/index
+	index := types.InvertedIndex{}
+	for word, fields := range page.terms.Fields {
+		score := 0
+		for _, frequency := range fields {
+			score += frequency
+		}
+		index[word] = types.Posting{
+			TermFrequency: score,
+			NormUrl:       page.canonical,
+			Fields:        fields,
+			Positions:     page.terms.Positions[word],
+		}
+	}
+
+	err = db.AddIndex(index)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return nil
+}
+
+// indexPage indexes a fetched page and queues its links at depth+1.
+// a duplicate or near-duplicate of an indexed page is not indexed, canonical and aliases point to that page instead.
+func indexPage(db *database.DataBase, pageUrl string, canonical string, aliases []string, depth int, html *html.Node, response types.Response, fetched int64) error {
+	page, err := parsePage(pageUrl, canonical, html, response.Content, response.Header)
+	if err != nil {
+		return err
+	}
+
+	//a noindex page is only crawled for its links, it must not stand in for an indexed one
+	if terms := page.contentTerms(); !page.robots.NoIndex && len(terms) >= minFingerprintTerms {
+		original, err := db.FindNearDuplicate(canonical, utilities.SimHash(terms))
+		if err != nil {
+			return err
+		}
+		if original != "" {
+			log.Printf("page: %v is a near-duplicate of %v\n", canonical, original)
+			metrics.DedupHits.WithLabelValues(metrics.DedupNear).Inc()
+			if err = db.RecordDuplicate(canonical); err != nil {
+				log.Println(err)
+			}
+			return db.AddAliases(original, append([]string{canonical}, aliases...))
+		}
+	}
+
+	err = writePage(db, page, pageUrl, response.Content, response.StatusCode)
+	if errors.Is(err, database.ErrDuplicateContent) {
+		log.Printf("content from page %s already exists\n", canonical)
+		metrics.DedupHits.WithLabelValues(metrics.DedupExact).Inc()
+		if err = db.RecordDuplicate(canonical); err != nil {
+			log.Println(err)
+		}
+		original, err := db.OriginalOf(response.Content)
+		if err != nil {
+			return err
+		}
+		if original == "" || original == canonical {
+			return nil
+		}
+		return db.AddAliases(original, append([]string{canonical}, aliases...))
+	}
+	if err != nil {
+		return err
+	}
+
+	//kept so the index can be rebuilt without crawling, noarchive pages may not be kept
+	if Pages != nil {
+		if page.robots.NoArchive {
+			err = Pages.Delete(canonical)
+		} else {
+			err = Pages.Put(types.StoredPage{
+				NormUrl:    canonical,
+				PageUrl:    pageUrl,
+				StatusCode: response.StatusCode,
+				Header:     response.Header,
+				Fetched:    fetched,
+				Content:    response.Content,
+			})
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	var scopeErr *database.ScopeError
+	for _, newUrl := range page.outLinks {
+		//log.Printf("attempting to add url: %v to queue\n", newUrl)
+		err = db.PushUrl(newUrl, depth+1)
+		if err != nil && !errors.As(err, &scopeErr) {
+			log.Printf("Could not add url: %v to queue %v\n", newUrl, err)
+		}
+	}
+
+	return nil
+}
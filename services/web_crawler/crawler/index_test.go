@@ -0,0 +1,62 @@
+package crawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestCrawlFollowsRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/moved", http.StatusMovedPermanently))
+	mux.Handle("/moved", http.RedirectHandler("/page", http.StatusFound))
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><link rel="canonical" href="/page?ref=canonical"></head><body></body></html>`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	body, response, err := Crawl(server.URL + "/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{server.URL + "/moved", server.URL + "/page"}
+	if !reflect.DeepEqual(response.Redirects, expected) {
+		t.Errorf("expected redirects %v got %v", expected, response.Redirects)
+	}
+	if response.FinalUrl != server.URL+"/page" {
+		t.Errorf("expected final url %v got %v", server.URL+"/page", response.FinalUrl)
+	}
+
+	if canonical := canonicalUrl(response.FinalUrl, body); canonical != server.URL+"/page?ref=canonical" {
+		t.Errorf("expected canonical from link tag got %v", canonical)
+	}
+}
+
+func TestCrawlStopsRedirectLoops(t *testing.T) {
+	server := httptest.NewServer(http.RedirectHandler("/loop", http.StatusFound))
+	defer server.Close()
+
+	_, _, err := Crawl(server.URL + "/loop")
+	if err == nil {
+		t.Fatal("expected an error for a redirect loop")
+	}
+}
+
+func TestCanonicalUrlIgnoresOtherHosts(t *testing.T) {
+	page := "https://example.com/page"
+	body, err := html.Parse(strings.NewReader(`<html><head><link rel="canonical" href="https://spam.example.org/page"></head></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if canonical := canonicalUrl(page, body); canonical != page {
+		t.Errorf("expected %v got %v", page, canonical)
+	}
+}
@@ -0,0 +1,129 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+	"web_crawler/database"
+	"web_crawler/metrics"
+)
+
+// longest a new worker waits before its first job, spreads the first requests out
+const maxStartJitter = 3 * time.Second
+
+// Pool runs crawl workers until its context is done. Workers can be added,
+// stopped, paused and resumed while the pool runs.
+type Pool struct {
+	ctx    context.Context
+	prefix string
+	job    func(workerID string)
+
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	cancels []context.CancelFunc
+	paused  atomic.Bool
+}
+
+// NewPool returns an empty pool, workerPrefix has to be unique across crawler
+// instances since worker ids name the processing lists in redis
+func NewPool(ctx context.Context, db *database.DataBase, workerPrefix string) *Pool {
+	return &Pool{
+		ctx:    ctx,
+		prefix: workerPrefix,
+		job: func(workerID string) {
+			CrawlJob(db, workerID)
+		},
+	}
+}
+
+// Resize starts or stops workers until n are running.
+// A stopped worker finishes the url it is crawling first.
+func (p *Pool) Resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.cancels) < n {
+		ctx, cancel := context.WithCancel(p.ctx)
+		workerID := fmt.Sprintf("%v-%d", p.prefix, len(p.cancels))
+		p.cancels = append(p.cancels, cancel)
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.work(ctx, workerID)
+		}()
+	}
+
+	for len(p.cancels) > n {
+		last := len(p.cancels) - 1
+		p.cancels[last]()
+		p.cancels = p.cancels[:last]
+	}
+}
+
+// Size returns the number of running workers
+func (p *Pool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.cancels)
+}
+
+// Pause makes every worker stop taking urls after its current one
+func (p *Pool) Pause() {
+	p.paused.Store(true)
+}
+
+func (p *Pool) Resume() {
+	p.paused.Store(false)
+}
+
+func (p *Pool) Paused() bool {
+	return p.paused.Load()
+}
+
+// Wait blocks until every worker stopped
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context, workerID string) {
+	jitter := time.Duration(rand.Int63n(int64(maxStartJitter)))
+	fmt.Printf("Worker %v starting after %v\n", workerID, jitter)
+	if !sleepCtx(ctx, jitter) {
+		return
+	}
+	metrics.SetWorkerState(workerID, metrics.WorkerIdle)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Printf("Worker %v stopping\n", workerID)
+			metrics.RemoveWorker(workerID)
+			return
+		default:
+		}
+
+		if p.paused.Load() {
+			metrics.SetWorkerState(workerID, metrics.WorkerPaused)
+			sleepCtx(ctx, maxIdleWait)
+			continue
+		}
+		p.job(workerID)
+	}
+}
+
+// sleeps for d, returns false if ctx was done first
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,84 @@
+package crawler
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testPool(ctx context.Context) (*Pool, *sync.Map) {
+	jobs := &sync.Map{}
+	p := &Pool{ctx: ctx, prefix: "test"}
+	p.job = func(workerID string) {
+		n, _ := jobs.LoadOrStore(workerID, new(atomic.Int64))
+		n.(*atomic.Int64).Add(1)
+		time.Sleep(time.Millisecond)
+	}
+	return p, jobs
+}
+
+func countJobs(jobs *sync.Map) int64 {
+	var total int64
+	jobs.Range(func(_, n any) bool {
+		total += n.(*atomic.Int64).Load()
+		return true
+	})
+	return total
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(2 * maxStartJitter)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPoolResize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p, jobs := testPool(ctx)
+
+	p.Resize(3)
+	if p.Size() != 3 {
+		t.Errorf("expected 3 workers got %d", p.Size())
+	}
+	waitFor(t, func() bool {
+		count := 0
+		jobs.Range(func(_, _ any) bool { count++; return true })
+		return count == 3
+	})
+
+	p.Resize(1)
+	if p.Size() != 1 {
+		t.Errorf("expected 1 worker got %d", p.Size())
+	}
+
+	cancel()
+	p.Wait()
+}
+
+func TestPoolPause(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p, jobs := testPool(ctx)
+	p.Resize(1)
+	waitFor(t, func() bool { return countJobs(jobs) > 0 })
+
+	p.Pause()
+	//let the current job finish
+	time.Sleep(50 * time.Millisecond)
+	paused := countJobs(jobs)
+	time.Sleep(50 * time.Millisecond)
+	if countJobs(jobs) != paused {
+		t.Error("expected no jobs while paused")
+	}
+
+	p.Resume()
+	waitFor(t, func() bool { return countJobs(jobs) > paused })
+
+	cancel()
+	p.Wait()
+}
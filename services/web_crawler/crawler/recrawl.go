@@ -0,0 +1,88 @@
+package crawler
+
+import (
+	"context"
+	"log"
+	"time"
+	"web_crawler/database"
+	"web_crawler/types"
+)
+
+// revisit intervals in seconds. a page that changed between two fetches is
+// revisited twice as often, one that didn't half as often
+const initialRecrawlInterval = 24 * 60 * 60
+const minRecrawlInterval = 60 * 60
+const maxRecrawlInterval = 30 * 24 * 60 * 60
+
+// weight of the latest fetch in the change rate estimate
+const changeRateWeight = 0.3
+
+// how often due pages are moved into the frontier and how many at a time
+const recrawlInterval = 1 * time.Minute
+const recrawlBatchSize = 1000
+
+// returns the state after a fetch of a page, changed tells whether its content differed from the last one
+func updateFetchState(state types.FetchState, response types.Response, changed bool, now int64) types.FetchState {
+	if state.LastFetched == 0 {
+		//nothing to compare with yet
+		state.Interval = initialRecrawlInterval
+		state.ChangeRate = 0.5
+		state.LastChanged = now
+	} else if changed {
+		state.Interval /= 2
+		state.ChangeRate = changeRateWeight + (1-changeRateWeight)*state.ChangeRate
+		state.LastChanged = now
+	} else {
+		state.Interval *= 2
+		state.ChangeRate = (1 - changeRateWeight) * state.ChangeRate
+	}
+	state.Interval = min(max(state.Interval, minRecrawlInterval), maxRecrawlInterval)
+	state.LastFetched = now
+
+	//a 304 may carry fresh validators, an empty one keeps the old
+	if etag := response.Header.Get("ETag"); etag != "" {
+		state.ETag = etag
+	}
+	if lastModified := response.Header.Get("Last-Modified"); lastModified != "" {
+		state.LastModified = lastModified
+	}
+
+	return state
+}
+
+// PendingWork returns the queued and leased urls plus the pages waiting to be revisited
+func PendingWork(db *database.DataBase) (int64, error) {
+	pending, err := db.PendingUrls()
+	if err != nil {
+		return 0, err
+	}
+
+	scheduled, err := db.ScheduledRecrawls()
+	if err != nil {
+		return 0, err
+	}
+
+	return pending + scheduled, nil
+}
+
+// StartRecrawler queues pages that are due to be revisited until ctx is done
+func StartRecrawler(ctx context.Context, db *database.DataBase) {
+	ticker := time.NewTicker(recrawlInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			queued, err := db.QueueDueRecrawls(recrawlBatchSize)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if queued > 0 {
+				log.Printf("queued %d pages for recrawl\n", queued)
+			}
+		}
+	}
+}
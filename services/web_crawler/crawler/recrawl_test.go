@@ -0,0 +1,67 @@
+package crawler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"web_crawler/types"
+)
+
+func TestUpdateFetchState(t *testing.T) {
+	response := types.Response{Header: http.Header{}}
+	response.Header.Set("ETag", `"v1"`)
+
+	state := updateFetchState(types.FetchState{NormUrl: "https://a.example/"}, response, true, 1000)
+	if state.Interval != initialRecrawlInterval || state.ETag != `"v1"` || state.LastFetched != 1000 {
+		t.Errorf("unexpected state after first fetch %+v", state)
+	}
+
+	//a 304 without validators keeps the old etag and backs off
+	unchanged := updateFetchState(state, types.Response{}, false, 2000)
+	if unchanged.Interval != 2*initialRecrawlInterval || unchanged.ETag != `"v1"` || unchanged.LastChanged != 1000 {
+		t.Errorf("unexpected state after unchanged fetch %+v", unchanged)
+	}
+	if unchanged.ChangeRate >= state.ChangeRate {
+		t.Errorf("change rate should drop, was %v now %v", state.ChangeRate, unchanged.ChangeRate)
+	}
+
+	changed := updateFetchState(state, response, true, 3000)
+	if changed.Interval != initialRecrawlInterval/2 || changed.LastChanged != 3000 {
+		t.Errorf("unexpected state after changed fetch %+v", changed)
+	}
+	if changed.ChangeRate <= state.ChangeRate {
+		t.Errorf("change rate should rise, was %v now %v", state.ChangeRate, changed.ChangeRate)
+	}
+
+	for range 20 {
+		changed = updateFetchState(changed, response, true, 4000)
+	}
+	if changed.Interval != minRecrawlInterval {
+		t.Errorf("expected interval to bottom out at %d got %d", minRecrawlInterval, changed.Interval)
+	}
+}
+
+func TestCrawlIfChanged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("<html><head><title>v1</title></head></html>"))
+	}))
+	defer server.Close()
+
+	_, response, err := CrawlIfChanged(server.URL, types.FetchState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := updateFetchState(types.FetchState{NormUrl: server.URL}, response, true, 1000)
+
+	_, response, err = CrawlIfChanged(server.URL, state)
+	if !errors.Is(err, ErrNotModified) || response.StatusCode != http.StatusNotModified {
+		t.Errorf("expected not modified got %v %d", err, response.StatusCode)
+	}
+}
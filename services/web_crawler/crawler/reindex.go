@@ -0,0 +1,80 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"web_crawler/database"
+	"web_crawler/pagestore"
+	"web_crawler/types"
+)
+
+// ReindexStats counts what Reindex did
+type ReindexStats struct {
+	Pages      int
+	Duplicates int
+	Failed     int
+}
+
+// Reindex rebuilds the inverted index, documents, image index and link graph
+// from the pages in store with the current parser. The new index is built
+// next to the live one and swapped in once it is complete, queries keep
+// using the old one until then. Crawlers should be paused while it runs,
+// whatever they index in the meantime is lost with the old index.
+func Reindex(db *database.DataBase, store pagestore.Store) (ReindexStats, error) {
+	stats := ReindexStats{}
+
+	staging, err := db.StagingIndex()
+	if err != nil {
+		return stats, err
+	}
+
+	err = store.Walk(func(stored types.StoredPage, err error) error {
+		if err != nil {
+			log.Printf("could not read stored page %v\n", err)
+			stats.Failed++
+			return nil
+		}
+
+		err = reindexPage(staging, stored)
+		switch {
+		case errors.Is(err, database.ErrDuplicateContent):
+			stats.Duplicates++
+		case err != nil:
+			log.Printf("could not reindex %v %v\n", stored.NormUrl, err)
+			stats.Failed++
+		default:
+			stats.Pages++
+			if stats.Pages%1000 == 0 {
+				log.Printf("reindexed %d pages\n", stats.Pages)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("could not read page store %v", err)
+	}
+	//an empty store would swap in an empty index
+	if stats.Pages == 0 {
+		return stats, errors.New("no page could be reindexed, the live index was kept")
+	}
+
+	if err = db.SwapIndex(staging); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+func reindexPage(staging *database.DataBase, stored types.StoredPage) error {
+	node, err := parseContent(stored.Header, stored.Content)
+	if err != nil {
+		return err
+	}
+
+	page, err := parsePage(stored.PageUrl, stored.NormUrl, node, stored.Content, stored.Header)
+	if err != nil {
+		return err
+	}
+
+	return writePage(staging, page, stored.PageUrl, stored.Content, stored.StatusCode)
+}
@@ -0,0 +1,130 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+	"web_crawler/database"
+	"web_crawler/types"
+	"web_crawler/utilities"
+	"web_crawler/warc"
+)
+
+// ReplayStats counts what Replay did
+type ReplayStats struct {
+	Files  int
+	Pages  int
+	Failed int
+}
+
+// Replay indexes every response archived in the warc files of dir without
+// touching the network. Pages that are indexed already are indexed again so
+// a changed parser takes effect.
+func Replay(db *database.DataBase, dir string) (ReplayStats, error) {
+	stats := ReplayStats{}
+
+	files, err := warc.Files(dir)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, path := range files {
+		log.Printf("replaying %v\n", path)
+		if err = replayFile(db, path, &stats); err != nil {
+			return stats, err
+		}
+		stats.Files++
+	}
+
+	return stats, nil
+}
+
+func replayFile(db *database.DataBase, path string, stats *ReplayStats) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open warc file %v %v", path, err)
+	}
+	defer f.Close()
+
+	reader, err := warc.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("could not read warc file %v %v", path, err)
+	}
+
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read warc file %v %v", path, err)
+		}
+		if record.Type() != "response" {
+			continue
+		}
+
+		if err = replayRecord(db, record); err != nil {
+			log.Printf("could not replay %v %v\n", record.TargetURI(), err)
+			stats.Failed++
+			continue
+		}
+		stats.Pages++
+	}
+}
+
+// feeds an archived response through the same path a fetched one takes
+func replayRecord(db *database.DataBase, record warc.Record) error {
+	link, err := utilities.CanonicalizeUrl(record.TargetURI())
+	if err != nil || link == "" {
+		return fmt.Errorf("invalid target uri %v", err)
+	}
+
+	resp, err := record.Response()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read archived body %v", err)
+	}
+
+	content, err := decodeContent(body, contentType(resp.Header, string(body)))
+	if err != nil {
+		return err
+	}
+
+	node, err := parseContent(resp.Header, content)
+	if err != nil {
+		return err
+	}
+
+	response := types.Response{
+		Url:        link,
+		FinalUrl:   link,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Content:    content,
+	}
+
+	fetched := time.Now().Unix()
+	if date, err := time.Parse(time.RFC3339, record.Header.Get("WARC-Date")); err == nil {
+		fetched = date.Unix()
+	}
+
+	depth, err := db.GetDepth(link)
+	if err != nil {
+		return err
+	}
+
+	lastFetch, err := db.GetFetchState(link)
+	if err != nil {
+		return err
+	}
+
+	return storePage(db, link, lastFetch, depth, node, response, true, fetched)
+}
@@ -0,0 +1,76 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"web_crawler/database"
+	"web_crawler/handlers"
+	"web_crawler/types"
+	"web_crawler/utilities"
+)
+
+// how often the sitemap queue is checked once it ran empty
+const sitemapInterval = 10 * time.Second
+
+// StartSitemapIngester reads the sitemaps of the hosts queued with
+// QueueSitemaps one host after the other until ctx is done
+func StartSitemapIngester(ctx context.Context, db *database.DataBase) {
+	ticker := time.NewTicker(sitemapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for ctx.Err() == nil {
+				host, depth, err := db.PopSitemaps()
+				if err != nil {
+					log.Println(err)
+					break
+				}
+				if host == "" {
+					break
+				}
+
+				domain, err := db.GetDomain(host)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				ingestSitemaps(db, domain, depth)
+			}
+		}
+	}
+}
+
+// fetches the sitemaps of a domain and feeds their urls to the frontier at depth.
+// only urls on the domain's own host are taken.
+func ingestSitemaps(db *database.DataBase, domain types.Domain, depth int) {
+	entries, err := handlers.GetSitemapEntries(domain.Sitemaps)
+	if err != nil {
+		log.Printf("could not read all sitemaps of domain: %v %v\n", domain.Name, err)
+	}
+
+	added := 0
+	var scopeErr *database.ScopeError
+	for _, entry := range entries {
+		normUrl, err := utilities.CanonicalizeUrl(entry.Loc)
+		if err != nil || normUrl == "" || hostOf(normUrl) != domain.Name {
+			continue
+		}
+		entry.Loc = normUrl
+
+		if err = db.AddSitemapEntry(entry, depth); err != nil {
+			if !errors.As(err, &scopeErr) {
+				log.Println(err)
+			}
+			continue
+		}
+		added++
+	}
+
+	log.Printf("added %d urls from sitemaps of domain: %v\n", added, domain.Name)
+}
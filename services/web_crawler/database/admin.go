@@ -0,0 +1,142 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"web_crawler/types"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// hosts the admin api blocked, checked before every other scope rule
+const blockedDomainsKey = "blockeddomains"
+
+// the last recentErrorsLimit crawl errors, newest first
+const recentErrorsKey = "errors:recent"
+const recentErrorsLimit = 100
+
+// KEYS[1] = the host's queue, KEYS[2] = frontier:size, KEYS[3] = frontier:ready, KEYS[4] = urlset
+// ARGV[1] = host
+//
+// empties a host's queue. the urls are forgotten so they can be found again later.
+var purgeHostScript = redis.NewScript(`
+local urls = redis.call('LRANGE', KEYS[1], 0, -1)
+for _, link in ipairs(urls) do
+	redis.call('SREM', KEYS[4], link)
+end
+redis.call('DEL', KEYS[1])
+redis.call('DECRBY', KEYS[2], #urls)
+redis.call('ZREM', KEYS[3], ARGV[1])
+return #urls
+`)
+
+// BlockDomain rejects every url of host from now on and purges its queue.
+// Returns how many queued urls were dropped.
+func (db *DataBase) BlockDomain(host string) (int64, error) {
+	if err := db.client.SAdd(db.ctx, blockedDomainsKey, host).Err(); err != nil {
+		return 0, fmt.Errorf("could not block domain %v %v", host, err)
+	}
+	return db.PurgeHost(host)
+}
+
+func (db *DataBase) UnblockDomain(host string) error {
+	if err := db.client.SRem(db.ctx, blockedDomainsKey, host).Err(); err != nil {
+		return fmt.Errorf("could not unblock domain %v %v", host, err)
+	}
+	return nil
+}
+
+func (db *DataBase) DomainBlocked(host string) (bool, error) {
+	res, err := db.client.SIsMember(db.ctx, blockedDomainsKey, host).Result()
+	if err != nil {
+		return false, fmt.Errorf("could not check if domain %v is blocked %v", host, err)
+	}
+	return res, nil
+}
+
+// PurgeHost drops every queued url of host, returns how many there were
+func (db *DataBase) PurgeHost(host string) (int64, error) {
+	res, err := purgeHostScript.Run(db.ctx, db.client,
+		[]string{hostQueueKey(host), frontierSizeKey, frontierReadyKey, "urlset"},
+		host,
+	).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("could not purge queue of %v %v", host, err)
+	}
+	return res, nil
+}
+
+// HostQueueLength returns how many urls of host are queued
+func (db *DataBase) HostQueueLength(host string) (int64, error) {
+	res, err := db.client.LLen(db.ctx, hostQueueKey(host)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("could not get queue length of %v %v", host, err)
+	}
+	return res, nil
+}
+
+// ReadyHosts returns up to count hosts with queued urls and when each may be crawled next
+func (db *DataBase) ReadyHosts(count int64) (map[string]time.Time, error) {
+	res, err := db.client.ZRangeWithScores(db.ctx, frontierReadyKey, 0, count-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not read %v %v", frontierReadyKey, err)
+	}
+
+	hosts := make(map[string]time.Time, len(res))
+	for _, z := range res {
+		host, _ := z.Member.(string)
+		hosts[host] = time.UnixMilli(int64(z.Score))
+	}
+	return hosts, nil
+}
+
+// DomainPages returns how many urls of host were admitted to the frontier
+func (db *DataBase) DomainPages(host string) (int64, error) {
+	res, err := db.client.HGet(db.ctx, domainPagesKey, host).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not get pages of %v %v", host, err)
+	}
+	return res, nil
+}
+
+// AddRecentError keeps a crawl error for the admin api
+func (db *DataBase) AddRecentError(normUrl string, crawlErr error) error {
+	data, err := json.Marshal(types.CrawlError{
+		Url:   normUrl,
+		Error: crawlErr.Error(),
+		Time:  time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	pipe := db.client.TxPipeline()
+	pipe.LPush(db.ctx, recentErrorsKey, data)
+	pipe.LTrim(db.ctx, recentErrorsKey, 0, recentErrorsLimit-1)
+	if _, err = pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not record error of %v %v", normUrl, err)
+	}
+	return nil
+}
+
+// RecentErrors returns the last count crawl errors, newest first
+func (db *DataBase) RecentErrors(count int64) ([]types.CrawlError, error) {
+	res, err := db.client.LRange(db.ctx, recentErrorsKey, 0, count-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not get %v %v", recentErrorsKey, err)
+	}
+
+	crawlErrors := make([]types.CrawlError, 0, len(res))
+	for _, data := range res {
+		var crawlErr types.CrawlError
+		if err = json.Unmarshal([]byte(data), &crawlErr); err != nil {
+			return nil, fmt.Errorf("could not decode crawl error %v", err)
+		}
+		crawlErrors = append(crawlErrors, crawlErr)
+	}
+	return crawlErrors, nil
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"fmt"
+	"utils"
+)
+
+// aliases maps every url that served a page under another url (a redirect
+// or a <link rel="canonical">) to that canonical url. documents, postings and
+// backlinks are only ever stored under the canonical url.
+const aliasesKey = "aliases"
+
+func redirectsKey(normUrl string) string {
+	return "redirects:" + utils.HashUrl(normUrl)
+}
+
+// AddAliases points aliases at canonical and marks them as seen. The links
+// pages crawled earlier made to an alias are moved over to the canonical url,
+// in their outlinks and in the backlinks, so the link graph only has canonical urls.
+// The canonical url itself is left to be crawled, a page can declare one it isn't.
+func (db *DataBase) AddAliases(canonical string, aliases []string) error {
+	canonicalBacklinks := db.indexKey("backlinks:" + utils.HashUrl(canonical))
+
+	//pages that link to each alias, by url hash
+	sources := make(map[string][]string)
+	for _, alias := range aliases {
+		if alias == canonical || alias == "" {
+			continue
+		}
+		res, err := db.client.SMembers(db.ctx, db.indexKey("backlinks:"+utils.HashUrl(alias))).Result()
+		if err != nil {
+			return fmt.Errorf("could not get backlinks of %v %v", alias, err)
+		}
+		sources[alias] = res
+	}
+
+	pipe := db.client.TxPipeline()
+	for alias, linkedFrom := range sources {
+		aliasBacklinks := db.indexKey("backlinks:" + utils.HashUrl(alias))
+
+		pipe.HSet(db.ctx, aliasesKey, alias, canonical)
+		pipe.SAdd(db.ctx, "urlset", alias)
+		for _, source := range linkedFrom {
+			pipe.SRem(db.ctx, db.indexKey("outlinks:"+source), alias)
+			pipe.SAdd(db.ctx, db.indexKey("outlinks:"+source), canonical)
+		}
+		pipe.SUnionStore(db.ctx, canonicalBacklinks, canonicalBacklinks, aliasBacklinks)
+		pipe.Del(db.ctx, aliasBacklinks)
+	}
+	//the canonical may have been an alias of something else before
+	pipe.HDel(db.ctx, aliasesKey, canonical)
+	if _, err := pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not add aliases of %v %v", canonical, err)
+	}
+	return nil
+}
+
+// ResolveAliases replaces every known alias in urls with its canonical url, keeping the order
+func (db *DataBase) ResolveAliases(urls []string) ([]string, error) {
+	if len(urls) == 0 {
+		return urls, nil
+	}
+
+	res, err := db.client.HMGet(db.ctx, aliasesKey, urls...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve aliases %v", err)
+	}
+
+	resolved := make([]string, len(urls))
+	for i, normUrl := range urls {
+		resolved[i] = normUrl
+		if canonical, ok := res[i].(string); ok && canonical != "" {
+			resolved[i] = canonical
+		}
+	}
+	return resolved, nil
+}
+
+// AddRedirectChain stores the urls normUrl redirected through, in order
+func (db *DataBase) AddRedirectChain(normUrl string, chain []string) error {
+	pipe := db.client.TxPipeline()
+	pipe.Del(db.ctx, redirectsKey(normUrl))
+	pipe.RPush(db.ctx, redirectsKey(normUrl), chain)
+	if _, err := pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not store redirects of %v %v", normUrl, err)
+	}
+	return nil
+}
+
+// GetRedirectChain returns the urls normUrl redirected through on its last fetch
+func (db *DataBase) GetRedirectChain(normUrl string) ([]string, error) {
+	res, err := db.client.LRange(db.ctx, redirectsKey(normUrl), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not get redirects of %v %v", normUrl, err)
+	}
+	return res, nil
+}
+
+// DocumentExists reports whether a document was indexed under normUrl
+func (db *DataBase) DocumentExists(normUrl string) (bool, error) {
+	res, err := db.client.HExists(db.ctx, db.indexKey("document:"+utils.HashUrl(normUrl)), "url").Result()
+	if err != nil {
+		return false, fmt.Errorf("could not check document of %v %v", normUrl, err)
+	}
+	return res, nil
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"utils"
+)
+
+// links from one host count at most this many times for a term of a target,
+// a site repeating a link in its navigation would drown out everyone else
+const maxAnchorsPerHost = 3
+
+// anchor text terms are stored under the target url of the link:
+//
+//	fieldindex:anchor:<term>  target -> links from all hosts, each host capped at maxAnchorsPerHost
+//	anchorlength             target -> sum over the terms of fieldindex:anchor
+//	anchorcount:<target>     "<host> <term>" -> links from host, uncapped
+//	anchorsfrom:<source>     "<term> <target>" -> links from source, to take them back out
+const anchorLengthKey = "anchorlength"
+
+func anchorCountKey(target string) string {
+	return "anchorcount:" + utils.HashUrl(target)
+}
+
+func anchorsFromKey(source string) string {
+	return "anchorsfrom:" + utils.HashUrl(source)
+}
+
+// AddAnchors adds the anchor text terms of the links on source,
+// anchors maps target url -> term -> number of links with the term
+func (db *DataBase) AddAnchors(source string, anchors map[string]map[string]int) error {
+	if len(anchors) == 0 {
+		return nil
+	}
+
+	targets := make([]string, 0, len(anchors))
+	for target := range anchors {
+		targets = append(targets, target)
+	}
+	//text on a link to a redirect or duplicate url describes its canonical url
+	resolved, err := db.ResolveAliases(targets)
+	if err != nil {
+		return err
+	}
+
+	host := hostFromUrl(source)
+	for i, target := range targets {
+		if resolved[i] == source {
+			continue
+		}
+		for term, count := range anchors[target] {
+			if err = db.client.HIncrBy(db.ctx, db.indexKey(anchorsFromKey(source)), term+" "+resolved[i], int64(count)).Err(); err != nil {
+				return fmt.Errorf("could not add anchors of %v %v", source, err)
+			}
+			if err = db.addAnchorCount(host, term, resolved[i], count); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RemoveAnchors takes the anchor text terms of the links on source back out
+func (db *DataBase) RemoveAnchors(source string) error {
+	fromKey := db.indexKey(anchorsFromKey(source))
+
+	anchors, err := db.client.HGetAll(db.ctx, fromKey).Result()
+	if err != nil {
+		return fmt.Errorf("could not get anchors of %v %v", source, err)
+	}
+
+	host := hostFromUrl(source)
+	for field, value := range anchors {
+		term, target, _ := strings.Cut(field, " ")
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("could not parse anchor count %v %v", value, err)
+		}
+		if err = db.addAnchorCount(host, term, target, -count); err != nil {
+			return err
+		}
+	}
+
+	if err = db.client.Del(db.ctx, fromKey).Err(); err != nil {
+		return fmt.Errorf("could not remove anchors of %v %v", source, err)
+	}
+	return nil
+}
+
+// changes the links from host with term to target by delta and moves the
+// capped postings along. HINCRBY returns the new count, so concurrent
+// changes each see the count before and after their own.
+func (db *DataBase) addAnchorCount(host string, term string, target string, delta int) error {
+	countKey := db.indexKey(anchorCountKey(target))
+	field := host + " " + term
+
+	count, err := db.client.HIncrBy(db.ctx, countKey, field, int64(delta)).Result()
+	if err != nil {
+		return fmt.Errorf("could not count anchor %v for %v %v", term, target, err)
+	}
+
+	capped := min(int(count), maxAnchorsPerHost) - min(int(count)-delta, maxAnchorsPerHost)
+
+	pipe := db.client.TxPipeline()
+	if count <= 0 {
+		pipe.HDel(db.ctx, countKey, field)
+	}
+	if capped != 0 {
+		postingKey := db.indexKey(fieldIndexKey(utils.FieldAnchor, term))
+		pipe.ZIncrBy(db.ctx, postingKey, float64(capped), target)
+		pipe.ZIncrBy(db.ctx, db.indexKey(anchorLengthKey), float64(capped), target)
+		pipe.ZRemRangeByScore(db.ctx, postingKey, "-inf", "0")
+		pipe.ZRemRangeByScore(db.ctx, db.indexKey(anchorLengthKey), "-inf", "0")
+	}
+	if _, err = pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not add anchor %v for %v %v", term, target, err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The frontier keeps one queue per host (frontier:host:<host>) and a sorted
+// set (frontier:ready) of host -> unix ms when the host may be crawled again.
+// A worker only ever pops from a host whose ready time has passed, so crawl
+// delays are enforced by the queue instead of by requeueing.
+const frontierTag = "frontier"
+const frontierReadyKey = frontierTag + ":ready"
+const frontierSizeKey = frontierTag + ":size"
+
+// how many ready hosts popUrlScript looks at before giving up
+const frontierScanLimit = 16
+
+// KEYS[1] = frontier:ready, KEYS[2] = frontier:size,
+// KEYS[3] = frontier:leases, KEYS[4] = frontier:leases:owner, KEYS[5] = the worker's processing list,
+// then for every candidate host its queue and its domain hash
+// ARGV[1] = now in ms, ARGV[2] = default crawl delay in seconds,
+// ARGV[3] = lease deadline in ms, ARGV[4] = worker id, ARGV[5...] = the candidate hosts
+//
+// pops a url from the first candidate host that is still ready and has urls
+// queued, leases it to the worker and reserves that host for its crawl delay.
+// hosts whose queue ran dry are dropped from the ready set, PushUrl adds them back.
+var popUrlScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+for i = 5, #ARGV do
+	local host = ARGV[i]
+	local queue = KEYS[2 * i - 4]
+	local domain = KEYS[2 * i - 3]
+	local ready = redis.call('ZSCORE', KEYS[1], host)
+	if ready and tonumber(ready) <= now then
+		local link = redis.call('RPOP', queue)
+		if link then
+			local delay = tonumber(redis.call('HGET', domain, 'crawldelay'))
+			if not delay or delay <= 0 then
+				delay = tonumber(ARGV[2])
+			end
+			redis.call('ZADD', KEYS[1], now + delay * 1000, host)
+			redis.call('DECR', KEYS[2])
+			redis.call('ZADD', KEYS[3], ARGV[3], link)
+			redis.call('HSET', KEYS[4], link, ARGV[4])
+			redis.call('LPUSH', KEYS[5], link)
+			return link
+		end
+		redis.call('ZREM', KEYS[1], host)
+	end
+end
+return false
+`)
+
+func hostQueueKey(host string) string {
+	return frontierTag + ":host:" + host
+}
+
+func hostFromUrl(normUrl string) string {
+	u, err := url.Parse(normUrl)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// PushUrl adds a url found depth links away from a seed to its host's queue
+// unless it has been seen before. Urls out of scope are rejected with a *ScopeError.
+func (db *DataBase) PushUrl(normUrl string, depth int) error {
+	admitted, err := db.admit(normUrl, depth)
+	if err != nil || !admitted {
+		return err
+	}
+
+	return db.enqueue(normUrl, false)
+}
+
+// RequeueUrl puts a url that was popped but not crawled back at the front of its host's queue
+func (db *DataBase) RequeueUrl(normUrl string) error {
+	return db.enqueue(normUrl, true)
+}
+
+func (db *DataBase) enqueue(normUrl string, front bool) error {
+	host := hostFromUrl(normUrl)
+
+	pipe := db.client.TxPipeline()
+	if front {
+		//PopUrl takes from the right
+		pipe.RPush(db.ctx, hostQueueKey(host), normUrl)
+	} else {
+		pipe.LPush(db.ctx, hostQueueKey(host), normUrl)
+	}
+	pipe.Incr(db.ctx, frontierSizeKey)
+	//NX keeps the ready time of a host that is already waiting on its crawl delay
+	pipe.ZAddNX(db.ctx, frontierReadyKey, redis.Z{Member: host, Score: float64(time.Now().UnixMilli())})
+	if _, err := pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not push %v to frontier %v", normUrl, err)
+	}
+
+	return nil
+}
+
+// the single queue urls were kept in before the frontier
+const legacyQueueKey = "urlqueue"
+
+// MigrateUrlQueue moves the urls left in the old single queue into the
+// frontier, oldest first. They were admitted when they were queued so they
+// go in without being checked again. Returns how many were moved.
+func (db *DataBase) MigrateUrlQueue() (int, error) {
+	moved := 0
+	for {
+		//the url is only popped once it is in the frontier, a crash in between queues it twice instead of losing it
+		normUrl, err := db.client.LIndex(db.ctx, legacyQueueKey, -1).Result()
+		if err == redis.Nil {
+			return moved, nil
+		}
+		if err != nil {
+			return moved, fmt.Errorf("could not read %v %v", legacyQueueKey, err)
+		}
+
+		if err = db.enqueue(normUrl, false); err != nil {
+			return moved, err
+		}
+		if err = db.client.RPop(db.ctx, legacyQueueKey).Err(); err != nil {
+			return moved, fmt.Errorf("could not pop %v %v", legacyQueueKey, err)
+		}
+		moved++
+	}
+}
+
+// PopUrl leases a url from a host whose crawl delay has passed to workerID.
+// The lease has to be ended with AckUrl, FailUrl or ReleaseUrl before
+// leaseDuration runs out or the reaper hands the url to someone else.
+// An empty string means no host is ready right now.
+func (db *DataBase) PopUrl(workerID string) (string, error) {
+	now := time.Now()
+
+	//the script only touches keys it is given, so the candidates are read first
+	//and checked again inside it
+	hosts, err := db.client.ZRangeArgs(db.ctx, redis.ZRangeArgs{
+		Key:     frontierReadyKey,
+		Start:   "-inf",
+		Stop:    now.UnixMilli(),
+		ByScore: true,
+		Count:   frontierScanLimit,
+	}).Result()
+	if err != nil {
+		return "", fmt.Errorf("could not get ready hosts %v", err)
+	}
+	if len(hosts) == 0 {
+		return "", nil
+	}
+
+	keys := []string{frontierReadyKey, frontierSizeKey, leasesKey, leaseOwnerKey, processingKey(workerID)}
+	args := []any{now.UnixMilli(), DefaultCrawlDelay, now.Add(leaseDuration).UnixMilli(), workerID}
+	for _, host := range hosts {
+		keys = append(keys, hostQueueKey(host), domainTag+":"+host)
+		args = append(args, host)
+	}
+
+	res, err := popUrlScript.Run(db.ctx, db.client, keys, args...).Text()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("frontier could not be popped %v", err)
+	}
+
+	return res, nil
+}
+
+// ScheduleHost pushes the next allowed crawl of a host back to readyAt.
+// It never moves a ready time earlier and ignores hosts with nothing queued.
+func (db *DataBase) ScheduleHost(host string, readyAt time.Time) error {
+	err := db.client.ZAddArgs(db.ctx, frontierReadyKey, redis.ZAddArgs{
+		XX:      true,
+		GT:      true,
+		Members: []redis.Z{{Member: host, Score: float64(readyAt.UnixMilli())}},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("could not schedule host %v %v", host, err)
+	}
+	return nil
+}
+
+// NextReadyIn returns how long until the next host may be crawled
+func (db *DataBase) NextReadyIn() (time.Duration, error) {
+	wait, err := db.nextReady()
+	if err != nil || wait < 0 {
+		return 0, err
+	}
+	return wait, nil
+}
+
+// OverdueFor returns how long the host that has been ready the longest has
+// been waiting for a worker, 0 when every host is still on its crawl delay
+func (db *DataBase) OverdueFor() (time.Duration, error) {
+	wait, err := db.nextReady()
+	if err != nil || wait > 0 {
+		return 0, err
+	}
+	return -wait, nil
+}
+
+// time until the earliest ready time in the frontier, negative once it passed
+func (db *DataBase) nextReady() (time.Duration, error) {
+	res, err := db.client.ZRangeWithScores(db.ctx, frontierReadyKey, 0, 0).Result()
+	if err != nil {
+		return 0, fmt.Errorf("could not read %v %v", frontierReadyKey, err)
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+	return time.Until(time.UnixMilli(int64(res[0].Score))), nil
+}
+
+func (db *DataBase) UrlQueueLength() (int64, error) {
+	res, err := db.client.Get(db.ctx, frontierSizeKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve length of frontier %v", err)
+	}
+
+	if res < 0 {
+		log.Printf("%v is negative: %v", frontierSizeKey, res)
+		return 0, nil
+	}
+
+	return res, nil
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"web_crawler/types"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Popped urls are leased to a worker until it acks or fails them. Leases live
+// in frontier:leases (url -> deadline in unix ms), the owning worker in
+// frontier:leases:owner and every worker keeps its in-flight urls in
+// frontier:processing:<worker> so they can be inspected.
+const leasesKey = frontierTag + ":leases"
+const leaseOwnerKey = leasesKey + ":owner"
+const retriesKey = frontierTag + ":retries"
+const lastErrorKey = frontierTag + ":lasterror"
+const deadLetterKey = frontierTag + ":deadletter"
+
+// how long a worker may hold a url before the reaper takes it back
+const leaseDuration = 2 * time.Minute
+
+// a url that failed this many times is moved to the dead-letter list
+const maxRetries = 3
+
+// how often FailUrl reads the owner of a lease that changed hands under it
+const maxLeaseAttempts = 3
+
+// ErrLeaseLost is returned when a worker acks or releases a url whose lease
+// was reaped and may have been handed to another worker since
+var ErrLeaseLost = errors.New("lease is no longer held by the worker")
+
+func processingKey(workerID string) string {
+	return frontierTag + ":processing:" + workerID
+}
+
+// KEYS[1] = frontier:leases, KEYS[2] = frontier:leases:owner, KEYS[3] = frontier:retries,
+// KEYS[4] = frontier:lasterror, KEYS[5] = frontier:deadletter, KEYS[6] = the host's queue,
+// KEYS[7] = frontier:size, KEYS[8] = frontier:ready, KEYS[9] = the owner's processing list
+// ARGV[1] = url, ARGV[2] = reason, ARGV[3] = max retries, ARGV[4] = host, ARGV[5] = now in ms,
+// ARGV[6] = the owner KEYS[9] belongs to
+//
+// ends a lease as failed. the url goes back to the front of its host's queue
+// or to the dead-letter list once it ran out of retries. returns 0 if there
+// was no lease, 1 if it was requeued, 2 if it was dead-lettered and -1 without
+// changing anything if the lease has another owner than ARGV[6] by now.
+var failLeaseScript = redis.NewScript(`
+local link = ARGV[1]
+if not redis.call('ZSCORE', KEYS[1], link) then
+	return 0
+end
+local owner = redis.call('HGET', KEYS[2], link) or ''
+if owner ~= ARGV[6] then
+	return -1
+end
+redis.call('ZREM', KEYS[1], link)
+redis.call('HDEL', KEYS[2], link)
+if owner ~= '' then
+	redis.call('LREM', KEYS[9], 0, link)
+end
+redis.call('HSET', KEYS[4], link, ARGV[2])
+local retries = redis.call('HINCRBY', KEYS[3], link, 1)
+if retries >= tonumber(ARGV[3]) then
+	redis.call('HDEL', KEYS[3], link)
+	redis.call('LPUSH', KEYS[5], link)
+	return 2
+end
+redis.call('RPUSH', KEYS[6], link)
+redis.call('INCR', KEYS[7])
+redis.call('ZADD', KEYS[8], 'NX', ARGV[5], ARGV[4])
+return 1
+`)
+
+// KEYS[1] = frontier:leases, KEYS[2] = frontier:leases:owner, KEYS[3] = the worker's processing list,
+// KEYS[4] = frontier:retries, KEYS[5] = frontier:lasterror, KEYS[6] = the host's queue,
+// KEYS[7] = frontier:size, KEYS[8] = frontier:ready
+// ARGV[1] = url, ARGV[2] = worker id, ARGV[3] = 1 to requeue the url, ARGV[4] = host, ARGV[5] = now in ms
+//
+// ends a lease held by ARGV[2]. an acked url forgets its retries, a released
+// one goes back to the front of its host's queue. returns 1 if the lease was
+// ended and 0 without touching it if ARGV[2] doesn't hold it anymore.
+var endLeaseScript = redis.NewScript(`
+local link = ARGV[1]
+redis.call('LREM', KEYS[3], 0, link)
+if redis.call('HGET', KEYS[2], link) ~= ARGV[2] then
+	return 0
+end
+redis.call('ZREM', KEYS[1], link)
+redis.call('HDEL', KEYS[2], link)
+if ARGV[3] == '1' then
+	redis.call('RPUSH', KEYS[6], link)
+	redis.call('INCR', KEYS[7])
+	redis.call('ZADD', KEYS[8], 'NX', ARGV[5], ARGV[4])
+else
+	redis.call('HDEL', KEYS[4], link)
+	redis.call('HDEL', KEYS[5], link)
+end
+return 1
+`)
+
+func (db *DataBase) endLease(workerID string, normUrl string, requeue bool) error {
+	host := hostFromUrl(normUrl)
+	requeueArg := 0
+	if requeue {
+		requeueArg = 1
+	}
+
+	ended, err := endLeaseScript.Run(db.ctx, db.client,
+		[]string{leasesKey, leaseOwnerKey, processingKey(workerID), retriesKey, lastErrorKey, hostQueueKey(host), frontierSizeKey, frontierReadyKey},
+		normUrl, workerID, requeueArg, host, time.Now().UnixMilli(),
+	).Int()
+	if err != nil {
+		return err
+	}
+	if ended == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// AckUrl ends a lease after the url was handled. Returns ErrLeaseLost if the
+// worker doesn't hold the lease anymore.
+func (db *DataBase) AckUrl(workerID string, normUrl string) error {
+	if err := db.endLease(workerID, normUrl, false); err != nil {
+		return fmt.Errorf("could not ack %v %w", normUrl, err)
+	}
+	return nil
+}
+
+// ReleaseUrl ends a lease without counting it as a failure and puts the url back in the frontier.
+// Returns ErrLeaseLost if the worker doesn't hold the lease anymore.
+func (db *DataBase) ReleaseUrl(workerID string, normUrl string) error {
+	if err := db.endLease(workerID, normUrl, true); err != nil {
+		return fmt.Errorf("could not release %v %w", normUrl, err)
+	}
+	return nil
+}
+
+// FailUrl ends a lease as failed. The url is retried until it failed
+// maxRetries times and then moved to the dead-letter list.
+// Returns true if the url was dead-lettered.
+func (db *DataBase) FailUrl(normUrl string, reason string) (bool, error) {
+	host := hostFromUrl(normUrl)
+
+	//the owner's processing list has to be passed to the script, if the url
+	//was leased again in between the script refuses and the owner is read again
+	for range maxLeaseAttempts {
+		owner, err := db.client.HGet(db.ctx, leaseOwnerKey, normUrl).Result()
+		if err != nil && err != redis.Nil {
+			return false, fmt.Errorf("could not get lease owner of %v %v", normUrl, err)
+		}
+
+		res, err := failLeaseScript.Run(db.ctx, db.client,
+			[]string{leasesKey, leaseOwnerKey, retriesKey, lastErrorKey, deadLetterKey, hostQueueKey(host), frontierSizeKey, frontierReadyKey, processingKey(owner)},
+			normUrl, reason, maxRetries, host, time.Now().UnixMilli(), owner,
+		).Int()
+		if err != nil {
+			return false, fmt.Errorf("could not fail lease of %v %v", normUrl, err)
+		}
+		if res != -1 {
+			return res == 2, nil
+		}
+	}
+	return false, fmt.Errorf("could not fail lease of %v, its owner kept changing", normUrl)
+}
+
+// ReapLeases fails every lease that is past its deadline, returns how many were reaped.
+// A lease that could not be failed doesn't stop the others, their errors are joined.
+func (db *DataBase) ReapLeases() (int, error) {
+	expired, err := db.client.ZRangeByScore(db.ctx, leasesKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("could not get expired leases %v", err)
+	}
+
+	reaped := 0
+	var errs []error
+	for _, normUrl := range expired {
+		if _, err = db.FailUrl(normUrl, "lease expired"); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		reaped++
+	}
+
+	return reaped, errors.Join(errs...)
+}
+
+// PendingUrls returns the number of queued urls plus the number of leased ones
+func (db *DataBase) PendingUrls() (int64, error) {
+	queued, err := db.UrlQueueLength()
+	if err != nil {
+		return 0, err
+	}
+
+	leased, err := db.client.ZCard(db.ctx, leasesKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("could not count leases %v", err)
+	}
+
+	return queued + leased, nil
+}
+
+// DeadLetters returns the dead-lettered urls between start and stop, newest first
+func (db *DataBase) DeadLetters(start, stop int64) ([]types.DeadLetter, error) {
+	urls, err := db.client.LRange(db.ctx, deadLetterKey, start, stop).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not get %v %v", deadLetterKey, err)
+	}
+
+	deadLetters := make([]types.DeadLetter, 0, len(urls))
+	if len(urls) == 0 {
+		return deadLetters, nil
+	}
+
+	reasons, err := db.client.HMGet(db.ctx, lastErrorKey, urls...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not get %v %v", lastErrorKey, err)
+	}
+	for i, normUrl := range urls {
+		reason, _ := reasons[i].(string)
+		deadLetters = append(deadLetters, types.DeadLetter{Url: normUrl, Reason: reason})
+	}
+
+	return deadLetters, nil
+}
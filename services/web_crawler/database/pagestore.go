@@ -0,0 +1,62 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// raw pages are kept compressed under pagestore:<hash of the url>
+const pageStoreTag = "pagestore"
+
+func pageStoreKey(urlHash string) string {
+	return pageStoreTag + ":" + urlHash
+}
+
+func (db *DataBase) PutRawPage(urlHash string, data []byte) error {
+	if err := db.client.Set(db.ctx, pageStoreKey(urlHash), data, 0).Err(); err != nil {
+		return fmt.Errorf("could not store raw page %v %v", urlHash, err)
+	}
+	return nil
+}
+
+// GetRawPage returns nil if no page is stored under urlHash
+func (db *DataBase) GetRawPage(urlHash string) ([]byte, error) {
+	res, err := db.client.Get(db.ctx, pageStoreKey(urlHash)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get raw page %v %v", urlHash, err)
+	}
+	return res, nil
+}
+
+func (db *DataBase) DeleteRawPage(urlHash string) error {
+	if err := db.client.Del(db.ctx, pageStoreKey(urlHash)).Err(); err != nil {
+		return fmt.Errorf("could not delete raw page %v %v", urlHash, err)
+	}
+	return nil
+}
+
+// ScanRawPages calls fn with every stored page until it returns an error
+func (db *DataBase) ScanRawPages(fn func(data []byte) error) error {
+	iter := db.client.Scan(db.ctx, 0, pageStoreKey("*"), keyBatch).Iterator()
+	for iter.Next(db.ctx) {
+		data, err := db.client.Get(db.ctx, iter.Val()).Bytes()
+		if err == redis.Nil {
+			//deleted since the scan saw it
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not get raw page %v %v", iter.Val(), err)
+		}
+		if err = fn(data); err != nil {
+			return err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("could not scan raw pages %v", err)
+	}
+	return nil
+}
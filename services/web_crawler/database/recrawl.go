@@ -0,0 +1,252 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"utils"
+	"web_crawler/types"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fetch:<hash> holds the types.FetchState of a url and recrawl is a sorted
+// set of url -> unix seconds when it is due to be fetched again
+const fetchTag = "fetch"
+const recrawlKey = "recrawl"
+
+// a queued recrawl stays in recrawl until its fetch stores a new state. it is
+// moved this many seconds ahead when it is queued so a failed or dead-lettered
+// fetch is queued again, doubled for every attempt since the last successful one
+const recrawlRetryDelay = 60 * 60
+const maxRecrawlRetryDelay = 7 * 24 * 60 * 60
+
+func fetchKey(normUrl string) string {
+	return fetchTag + ":" + utils.HashUrl(normUrl)
+}
+
+// GetFetchState returns the state of the last fetch, a zero LastFetched means the url was never fetched
+func (db *DataBase) GetFetchState(normUrl string) (types.FetchState, error) {
+	res, err := db.client.HGetAll(db.ctx, fetchKey(normUrl)).Result()
+	if err != nil {
+		return types.FetchState{}, fmt.Errorf("could not get fetch state of %v %v", normUrl, err)
+	}
+
+	state := types.FetchState{NormUrl: normUrl}
+	if res["status"] != "" {
+		if state.StatusCode, err = strconv.Atoi(res["status"]); err != nil {
+			return types.FetchState{}, fmt.Errorf("status could not be converted to int %v %v", res["status"], err)
+		}
+	}
+	//a url whose fetches all failed only has a status
+	if res["lastfetched"] == "" {
+		return state, nil
+	}
+
+	state.ETag = res["etag"]
+	state.LastModified = res["lastmodified"]
+	state.ContentHash = res["contenthash"]
+	state.Canonical = res["canonical"]
+
+	ints := map[string]*int64{
+		"lastfetched": &state.LastFetched,
+		"lastchanged": &state.LastChanged,
+		"interval":    &state.Interval,
+	}
+	for field, dst := range ints {
+		if *dst, err = strconv.ParseInt(res[field], 10, 64); err != nil {
+			return types.FetchState{}, fmt.Errorf("%v could not be converted to int %v %v", field, res[field], err)
+		}
+	}
+
+	if state.ChangeRate, err = strconv.ParseFloat(res["changerate"], 64); err != nil {
+		return types.FetchState{}, fmt.Errorf("changerate could not be converted to float %v %v", res["changerate"], err)
+	}
+
+	return state, nil
+}
+
+// SetFetchStatus stores the final http status of the last fetch of a url,
+// failed fetches of urls that were never indexed included
+func (db *DataBase) SetFetchStatus(normUrl string, statusCode int) error {
+	err := db.client.HSet(db.ctx, fetchKey(normUrl), "url", normUrl, "status", statusCode).Err()
+	if err != nil {
+		return fmt.Errorf("could not set fetch status of %v %v", normUrl, err)
+	}
+	return nil
+}
+
+// ForgetFetchedContent drops the content hash, canonical url and validators
+// of the last fetch so the next fetch of the url is indexed whatever it returns
+func (db *DataBase) ForgetFetchedContent(normUrl string) error {
+	err := db.client.HDel(db.ctx, fetchKey(normUrl), "contenthash", "canonical", "etag", "lastmodified").Err()
+	if err != nil {
+		return fmt.Errorf("could not forget fetched content of %v %v", normUrl, err)
+	}
+	return nil
+}
+
+// SetFetchState stores the state of a fetch and schedules the next one Interval seconds after it
+func (db *DataBase) SetFetchState(state types.FetchState) error {
+	pipe := db.client.TxPipeline()
+	pipe.HSet(db.ctx, fetchKey(state.NormUrl),
+		"url", state.NormUrl,
+		"lastfetched", state.LastFetched,
+		"lastchanged", state.LastChanged,
+		"etag", state.ETag,
+		"lastmodified", state.LastModified,
+		"contenthash", state.ContentHash,
+		"canonical", state.Canonical,
+		"changerate", state.ChangeRate,
+		"interval", state.Interval,
+	)
+	pipe.HDel(db.ctx, fetchKey(state.NormUrl), "recrawlattempts")
+	pipe.ZAdd(db.ctx, recrawlKey, redis.Z{Member: state.NormUrl, Score: float64(state.LastFetched + state.Interval)})
+	if _, err := pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not set fetch state of %v %v", state.NormUrl, err)
+	}
+	return nil
+}
+
+// KEYS[1] = recrawl, KEYS[2] = fetch:<hash> of the url
+// ARGV[1] = url, ARGV[2] = now in unix seconds, ARGV[3] = retry delay, ARGV[4] = max retry delay
+//
+// claims a due recrawl by moving it to when it is retried, the delay doubles
+// with every attempt. returns 1 if it was claimed and 0 if it isn't due (anymore).
+var claimRecrawlScript = redis.NewScript(`
+local now = tonumber(ARGV[2])
+local due = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not due or tonumber(due) > now then
+	return 0
+end
+local attempts = redis.call('HINCRBY', KEYS[2], 'recrawlattempts', 1)
+local delay = math.min(tonumber(ARGV[3]) * 2 ^ (attempts - 1), tonumber(ARGV[4]))
+redis.call('ZADD', KEYS[1], now + math.floor(delay), ARGV[1])
+return 1
+`)
+
+// QueueDueRecrawls moves up to limit urls whose revisit time has passed into the frontier.
+// They stay scheduled, a fetch that doesn't store a new fetch state is queued again after a backoff.
+func (db *DataBase) QueueDueRecrawls(limit int64) (int, error) {
+	now := time.Now().Unix()
+	due, err := db.client.ZRangeByScore(db.ctx, recrawlKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now, 10),
+		Count: limit,
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("could not get due recrawls %v", err)
+	}
+
+	queued := 0
+	for _, normUrl := range due {
+		//whoever claims it queues it, another crawler instance may be doing the same
+		claimed, err := claimRecrawlScript.Run(db.ctx, db.client,
+			[]string{recrawlKey, fetchKey(normUrl)},
+			normUrl, now, recrawlRetryDelay, maxRecrawlRetryDelay,
+		).Int()
+		if err != nil {
+			return queued, fmt.Errorf("could not claim recrawl of %v %v", normUrl, err)
+		}
+		if claimed == 0 {
+			continue
+		}
+
+		if err = db.enqueue(normUrl, false); err != nil {
+			return queued, err
+		}
+		queued++
+	}
+
+	return queued, nil
+}
+
+// ScheduledRecrawls returns how many urls are waiting to be revisited
+func (db *DataBase) ScheduledRecrawls() (int64, error) {
+	res, err := db.client.ZCard(db.ctx, recrawlKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("could not count %v %v", recrawlKey, err)
+	}
+	return res, nil
+}
+
+// RemovePagePostings deletes everything a previous crawl of a page put in
+// index:*, fieldindex:*, positions:*, imageindex:*, outlinks:*, backlinks:*, anchors, contenthashes, contentowners, simhash
+// and its document so a changed page can be indexed again and a gone one isn't counted anymore
+func (db *DataBase) RemovePagePostings(normUrl string, contentHash string) error {
+	urlHash := utils.HashUrl(normUrl)
+	termsKey := db.indexKey("terms:" + urlHash)
+	imageTermsKey := db.indexKey("imageterms:" + urlHash)
+	documentKey := db.indexKey("document:" + urlHash)
+	outLinksKey := "outlinks:" + urlHash
+
+	terms, err := db.client.SMembers(db.ctx, termsKey).Result()
+	if err != nil {
+		return fmt.Errorf("could not get terms of %v %v", normUrl, err)
+	}
+
+	imageTerms, err := db.client.SMembers(db.ctx, imageTermsKey).Result()
+	if err != nil {
+		return fmt.Errorf("could not get image terms of %v %v", normUrl, err)
+	}
+
+	document, err := db.client.HMGet(db.ctx, documentKey, "url", "lang").Result()
+	if err != nil {
+		return fmt.Errorf("could not get document of %v %v", normUrl, err)
+	}
+
+	outLinks, err := db.client.SMembers(db.ctx, db.indexKey(outLinksKey)).Result()
+	if err != nil {
+		return fmt.Errorf("could not get outlinks of %v %v", normUrl, err)
+	}
+
+	if err = db.RemoveFingerprint(normUrl); err != nil {
+		return err
+	}
+	if err = db.RemoveAnchors(normUrl); err != nil {
+		return err
+	}
+
+	pipe := db.client.TxPipeline()
+	for _, term := range terms {
+		pipe.ZRem(db.ctx, db.indexKey("index:"+term), normUrl)
+		pipe.HDel(db.ctx, db.indexKey("positions:"+term), normUrl)
+		for _, field := range utils.IndexFields {
+			pipe.ZRem(db.ctx, db.indexKey(fieldIndexKey(field, term)), normUrl)
+		}
+	}
+	pipe.Del(db.ctx, termsKey)
+
+	for _, imageTerm := range imageTerms {
+		term, imageUrl, _ := strings.Cut(imageTerm, " ")
+		pipe.ZRem(db.ctx, db.indexKey("imageindex:"+term), imageUrl)
+	}
+	pipe.Del(db.ctx, imageTermsKey)
+
+	//the document is only counted once however often the page was indexed
+	if document[0] != nil {
+		if lang, _ := document[1].(string); lang != "" {
+			pipe.SRem(db.ctx, db.indexKey("lang:"+lang), normUrl)
+		}
+		pipe.Del(db.ctx, documentKey)
+		pipe.Decr(db.ctx, db.indexKey("domain:count"))
+	}
+
+	for _, outLink := range outLinks {
+		pipe.SRem(db.ctx, db.indexKey("backlinks:"+utils.HashUrl(outLink)), urlHash)
+	}
+	pipe.Del(db.ctx, db.indexKey(outLinksKey))
+	pipe.LRem(db.ctx, db.indexKey("outlinks:index"), 0, outLinksKey)
+
+	if contentHash != "" {
+		pipe.SRem(db.ctx, db.indexKey("contenthashes"), contentHash)
+		pipe.HDel(db.ctx, db.indexKey(contentOwnersKey), contentHash)
+	}
+
+	if _, err = pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not remove postings of %v %v", normUrl, err)
+	}
+
+	return nil
+}
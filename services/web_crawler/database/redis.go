@@ -4,10 +4,11 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
 	"strconv"
 	"utils"
+	"web_crawler/scope"
 	"web_crawler/types"
 	"github.com/redis/go-redis/v9"
 )
@@ -15,11 +16,19 @@ import (
 type DataBase struct {
 	client *redis.Client
 	ctx    context.Context
+	//nil accepts every url
+	scope *scope.Policy
+	//the index is the live one unless live is nil, then it is kept under indexPrefix, see StagingIndex
+	live        *liveIndex
+	indexPrefix string
 }
 
 const pageTag = "page"
 const domainTag = "domain"
 
+// seconds, used when robots.txt doesn't specify a crawl-delay. set from the config in main
+var DefaultCrawlDelay int64 = 1
+
 func (db *DataBase) Connect(addr string, database string, password string) error {
 	dbId, err := strconv.Atoi(database)
 	if err != nil {
@@ -33,6 +42,7 @@ func (db *DataBase) Connect(addr string, database string, password string) error
 	})
 
 	db.ctx = context.Background()
+	db.live = &liveIndex{}
 
 	_, err = db.client.Ping(db.ctx).Result()
 	if err != nil {
@@ -42,6 +52,14 @@ func (db *DataBase) Connect(addr string, database string, password string) error
 	return nil
 }
 
+// Ping checks that redis can be reached
+func (db *DataBase) Ping() error {
+	if err := db.client.Ping(db.ctx).Err(); err != nil {
+		return fmt.Errorf("could not ping db %v", err)
+	}
+	return nil
+}
+
 func (db *DataBase) PageExists(normPageUrl string) (bool, error) {
 	res, err := db.client.Exists(db.ctx, pageTag+":"+normPageUrl).Result()
 	if err != nil {
@@ -55,68 +73,105 @@ func (db *DataBase) PageExists(normPageUrl string) (bool, error) {
 	return true, nil
 }
 
+// returned by AddPage when a page with the same content was stored already
+var ErrDuplicateContent = errors.New("duplicate content")
+
+// contenthashes is the set of checksums of stored pages, contentowners maps
+// each checksum to the url the page was stored under
+const contentOwnersKey = "contentowners"
+
+// ContentHash is the checksum pages are deduplicated on
+func ContentHash(content string) string {
+	h := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(h[:])
+}
+
 func (db *DataBase) AddPage(page types.Page) error {
-	h := sha256.Sum256([]byte(page.Content))
-	checksum := hex.EncodeToString(h[:])
+	checksum := ContentHash(page.Content)
 
-	res, err := db.client.SIsMember(db.ctx, "contenthashes", checksum).Result()
+	res, err := db.client.SIsMember(db.ctx, db.indexKey("contenthashes"), checksum).Result()
 	if err != nil {
 		return err
 	}
 	if res {
-		log.Printf("content from page %s already exists", page.NormUrl)
-		return nil
+		return ErrDuplicateContent
 	}
-	db.client.SAdd(db.ctx, "contenthashes", checksum)
+	db.client.SAdd(db.ctx, db.indexKey("contenthashes"), checksum)
+	db.client.HSet(db.ctx, db.indexKey(contentOwnersKey), checksum, page.NormUrl)
 
+	//outlinks:index lists the unprefixed names, they are right once a staged index is swapped in
 	outLinksKey := "outlinks:" + utils.HashUrl(page.NormUrl)
 
+	//links to a redirect or duplicate url count for its canonical url
+	outLinks, err := db.ResolveAliases(page.OutLinks)
+	if err != nil {
+		return err
+	}
+
 	//add outlinks
-	db.client.SAdd(db.ctx, outLinksKey, page.OutLinks)
+	db.client.SAdd(db.ctx, db.indexKey(outLinksKey), outLinks)
 
 	//index for outlinks for deterministic fetching
-	if err = db.client.RPush(db.ctx, "outlinks:index", outLinksKey).Err(); err != nil {
+	if err = db.client.RPush(db.ctx, db.indexKey("outlinks:index"), outLinksKey).Err(); err != nil {
 		return fmt.Errorf("error when trying to push %v to outlinks:index %v", outLinksKey, err)
 	}
 
 	//add backlinks
-	for _, backlink := range page.OutLinks {
-		db.client.SAdd(db.ctx, "backlinks:"+utils.HashUrl(backlink), utils.HashUrl(page.NormUrl))
+	for _, backlink := range outLinks {
+		db.client.SAdd(db.ctx, db.indexKey("backlinks:"+utils.HashUrl(backlink)), utils.HashUrl(page.NormUrl))
 	}
 
 	return nil
 }
 
+// OriginalOf returns the url a page with the same content was stored under,
+// empty if there is none or it was stored before its url was kept
+func (db *DataBase) OriginalOf(content string) (string, error) {
+	original, err := db.client.HGet(db.ctx, db.indexKey(contentOwnersKey), ContentHash(content)).Result()
+	if err != nil && err != redis.Nil {
+		return "", fmt.Errorf("could not get %v %v", contentOwnersKey, err)
+	}
+	return original, nil
+}
+
+// AddDomain stores a domain, replacing the robots rules of an existing one
 func (db *DataBase) AddDomain(domain types.Domain) error {
 
 	if domain.CrawlDelay == 0 {
-		domain.CrawlDelay = 1
+		domain.CrawlDelay = DefaultCrawlDelay
 	}
 	crawlDelay := strconv.FormatInt(domain.CrawlDelay, 10)
 	lastCrawled := strconv.FormatInt(domain.LastCrawled, 10)
+	robotsExpires := strconv.FormatInt(domain.RobotsExpires, 10)
+	robotsUnavailable := "0"
+	if domain.RobotsUnavailable {
+		robotsUnavailable = "1"
+	}
 
 	hashFields := []string{
 		"crawldelay", crawlDelay,
 		"lastcrawled", lastCrawled,
+		"robotsexpires", robotsExpires,
+		"robotsunavailable", robotsUnavailable,
 	}
 
-	err := db.client.HSet(db.ctx, domainTag+":"+domain.Name, hashFields).Err()
-	if err != nil {
-		return fmt.Errorf("could not add domain %v to database %v", domain.Name, err)
+	sets := map[string][]string{
+		"allowed":    domain.Allowed,
+		"disallowed": domain.Disallowed,
+		"sitemaps":   domain.Sitemaps,
 	}
 
-	if len(domain.Allowed) > 0 {
-		err = db.client.SAdd(db.ctx, domainTag+":"+domain.Name+":"+"allowed", domain.Allowed).Err()
-		if err != nil {
-			return fmt.Errorf("could not add allowed to set of domain: %v %v", domain.Name, err)
+	pipe := db.client.TxPipeline()
+	pipe.HSet(db.ctx, domainTag+":"+domain.Name, hashFields)
+	for name, members := range sets {
+		key := domainTag + ":" + domain.Name + ":" + name
+		pipe.Del(db.ctx, key)
+		if len(members) > 0 {
+			pipe.SAdd(db.ctx, key, members)
 		}
 	}
-
-	if len(domain.Disallowed) > 0 {
-		err = db.client.SAdd(db.ctx, domainTag+":"+domain.Name+":"+"disallowed", domain.Disallowed).Err()
-		if err != nil {
-			return fmt.Errorf("could not add disallowed to set of domain: %v %v", domain.Disallowed, err)
-		}
+	if _, err := pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not add domain %v to database %v", domain.Name, err)
 	}
 
 	return nil
@@ -139,6 +194,16 @@ func (db *DataBase) GetDomain(domainName string) (types.Domain, error) {
 		return types.Domain{}, fmt.Errorf("lastcrawled could not be converted to int %v %v", res["lastcrawled"], err)
 	}
 
+	//domains stored before robots.txt was cached have neither field and count as expired
+	var robotsExpires int64
+	if res["robotsexpires"] != "" {
+		robotsExpires, err = strconv.ParseInt(res["robotsexpires"], 10, 64)
+		if err != nil {
+			return types.Domain{}, fmt.Errorf("robotsexpires could not be converted to int %v %v", res["robotsexpires"], err)
+		}
+	}
+	robotsUnavailable := res["robotsunavailable"] == "1"
+
 	allowed, err := db.client.SMembers(db.ctx, domainTag+":"+domainName+":"+"allowed").Result()
 	if err != nil {
 		return types.Domain{}, fmt.Errorf("could not retrieve allowed for domain: %v %v", domainName, err)
@@ -149,12 +214,21 @@ func (db *DataBase) GetDomain(domainName string) (types.Domain, error) {
 		return types.Domain{}, fmt.Errorf("could not retrieve disallowed for domain: %v %v", domainName, err)
 	}
 
+	sitemaps, err := db.client.SMembers(db.ctx, domainTag+":"+domainName+":"+"sitemaps").Result()
+	if err != nil {
+		return types.Domain{}, fmt.Errorf("could not retrieve sitemaps for domain: %v %v", domainName, err)
+	}
+
 	return types.Domain{
 		Name:        domainName,
 		CrawlDelay:  crawlDelay,
 		LastCrawled: lastCrawled,
 		Allowed:     allowed,
 		Disallowed:  disallowed,
+		Sitemaps:    sitemaps,
+
+		RobotsExpires:     robotsExpires,
+		RobotsUnavailable: robotsUnavailable,
 	}, nil
 }
 
@@ -176,107 +250,156 @@ func (db *DataBase) DomainExists(domainName string) (bool, error) {
 	return res > 0, nil
 }
 
-// Queue stuff
-func (db *DataBase) PushUrl(normUrl string) error {
-
-	exists, err := db.client.SIsMember(db.ctx, "urlset", normUrl).Result()
+func (db *DataBase) RemoveUrlFromSet(normUrl string) error {
+	err := db.client.SRem(db.ctx, "urlset", normUrl).Err()
 	if err != nil {
-		return fmt.Errorf("%v", err)
+		return fmt.Errorf("could not remove %v from urlset %v", normUrl, err)
 	}
+	return nil
+}
 
-	if exists {
-		//log.Printf("found %v results for url: %v", exists, normUrl)
-		return nil
-	}
+func (db *DataBase) AddIndex(index types.InvertedIndex) error {
+	for term, posting := range index {
+		err := db.client.ZAdd(db.ctx, db.indexKey("index:"+term), redis.Z{Member: posting.NormUrl, Score: float64(posting.TermFrequency)}).Err()
+		if err != nil {
+			return fmt.Errorf("could not add index to database %v", err)
+		}
 
-	res, err := db.client.SAdd(db.ctx, "urlset", normUrl).Result()
-	if err != nil {
-		return fmt.Errorf("could not add url %v to set %v", normUrl, err)
-	}
-	if res == 0 {
-		log.Printf("could not add: %v to urlset res: %v", normUrl, res)
-		return nil
-	}
+		//phrase and proximity queries read where the term is on the page
+		if len(posting.Positions) > 0 {
+			err = db.client.HSet(db.ctx, db.indexKey("positions:"+term), posting.NormUrl, utils.EncodePositions(posting.Positions)).Err()
+			if err != nil {
+				return fmt.Errorf("could not add positions to database %v", err)
+			}
+		}
 
-	err = db.client.LPush(db.ctx, "urlqueue", normUrl).Err()
-	if err != nil {
-		return fmt.Errorf("could not push %v to urlqueue %v", normUrl, err)
-	}
+		//the same posting split by the field the term was found in
+		for field, frequency := range posting.Fields {
+			err = db.client.ZAdd(db.ctx, db.indexKey(fieldIndexKey(field, term)), redis.Z{Member: posting.NormUrl, Score: float64(frequency)}).Err()
+			if err != nil {
+				return fmt.Errorf("could not add %v index to database %v", field, err)
+			}
+		}
 
+		//remember the terms of every page so its postings can be removed when it changes
+		err = db.client.SAdd(db.ctx, db.indexKey("terms:"+utils.HashUrl(posting.NormUrl)), term).Err()
+		if err != nil {
+			return fmt.Errorf("could not add term %v for %v %v", term, posting.NormUrl, err)
+		}
+	}
 	return nil
 }
 
-func (db *DataBase) PopUrl() (string, error) {
-	res, err := db.client.BRPop(db.ctx, 0, "urlqueue").Result()
+func fieldIndexKey(field string, term string) string {
+	return "fieldindex:" + field + ":" + term
+}
+
+func (db *DataBase) AddDocument(document types.Document) error {
+	key := db.indexKey("document:" + utils.HashUrl(document.NormUrl))
+
+	//a recrawled document replaces the old one and must not be counted twice
+	old, err := db.client.HMGet(db.ctx, key, "url", "lang").Result()
 	if err != nil {
-		return "", fmt.Errorf("urlqueue could not be popped %v", err)
+		return fmt.Errorf("could not check if document for url: %v exists %v", document.NormUrl, err)
 	}
+	exists := old[0] != nil
+	oldLang, _ := old[1].(string)
 
-	//Got rid of this because we don't want to crawl a website twice
-	//err = db.client.SRem(db.ctx, "urlset", res[1]).Err()
-	//if err != nil {
-	//	return "", fmt.Errorf("could not remove %v from urlset %v", res[1], err)
-	//}
+	err = db.client.HSet(db.ctx, key, "url", document.NormUrl, "title", document.Title, "length", document.Length, "lang", document.Lang).Err()
+	if err != nil {
+		return fmt.Errorf("could not add document for url: %v to database %v", document.NormUrl, err)
+	}
 
-	return res[1], nil
-}
+	if err = db.setLanguage(document.NormUrl, oldLang, document.Lang); err != nil {
+		return err
+	}
 
-func (db *DataBase) RemoveUrlFromSet(normUrl string) error {
-	err := db.client.SRem(db.ctx, "urlset", normUrl).Err()
-	if err != nil {
-		return fmt.Errorf("could not remove %v from urlset %v", normUrl, err)
+	if exists {
+		return nil
 	}
-	return nil
-}
 
-func (db *DataBase) UrlQueueLength() (int64, error) {
-	res, err := db.client.LLen(db.ctx, "urlqueue").Result()
+	err = db.client.Incr(db.ctx, db.indexKey("domain:count")).Err()
 	if err != nil {
-		return 0, fmt.Errorf("could not retrieve length of urlqueue %v", err)
+		return fmt.Errorf("failed to increment domain:count %v", err)
 	}
 
-	return res, nil
+	return nil
 }
 
-func (db *DataBase) AddIndex(index types.InvertedIndex) error {
-	for term, posting := range index {
-		err := db.client.ZAdd(db.ctx, "index:"+term, redis.Z{Member: posting.NormUrl, Score: float64(posting.TermFrequency)}).Err()
-		if err != nil {
-			return fmt.Errorf("could not add index to database %v", err)
+// moves normUrl from the set of documents in oldLang to the one of lang,
+// queries filtered by language intersect their candidates with it
+func (db *DataBase) setLanguage(normUrl string, oldLang string, lang string) error {
+	if oldLang != "" && oldLang != lang {
+		if err := db.client.SRem(db.ctx, db.indexKey("lang:"+oldLang), normUrl).Err(); err != nil {
+			return fmt.Errorf("could not remove %v from language %v %v", normUrl, oldLang, err)
+		}
+	}
+	if lang != "" {
+		if err := db.client.SAdd(db.ctx, db.indexKey("lang:"+lang), normUrl).Err(); err != nil {
+			return fmt.Errorf("could not add %v to language %v %v", normUrl, lang, err)
 		}
 	}
 	return nil
 }
 
-func (db *DataBase) AddDocument(document types.Document) error {
-	err := db.client.HSet(db.ctx, "document:"+utils.HashUrl(document.NormUrl), "url", document.NormUrl, "title", document.Title, "length", document.Length).Err()
-	if err != nil {
-		return fmt.Errorf("could not add document for url: %v to database %v", document.NormUrl, err)
+// AddSkippedUrl records why a fetched url wasn't indexed, skipped:count keeps a tally per reason
+func (db *DataBase) AddSkippedUrl(normUrl string, reason string, detail string) error {
+	pipe := db.client.TxPipeline()
+	pipe.HSet(db.ctx, "skipped", normUrl, reason+": "+detail)
+	pipe.HIncrBy(db.ctx, "skipped:count", reason, 1)
+	if _, err := pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not record skipped url: %v %v", normUrl, err)
 	}
+	return nil
+}
 
-	err = db.client.Incr(db.ctx, "domain:count").Err()
+// SkippedCounts returns how many urls were skipped for each reason
+func (db *DataBase) SkippedCounts() (map[string]int64, error) {
+	res, err := db.client.HGetAll(db.ctx, "skipped:count").Result()
 	if err != nil {
-		return fmt.Errorf("failed to increment domain:count %v", err)
+		return nil, fmt.Errorf("could not get skipped:count %v", err)
 	}
 
-	return nil
+	counts := make(map[string]int64, len(res))
+	for reason, count := range res {
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse skipped count %v %v", count, err)
+		}
+		counts[reason] = n
+	}
+	return counts, nil
 }
 
-func (db *DataBase) AddImageIndex(index types.ImageIndex) error {
+// AddImageIndex adds the image postings of the page normUrl,
+// imageterms:<hash> keeps them so they can be removed with the page
+func (db *DataBase) AddImageIndex(normUrl string, index types.ImageIndex) error {
+	imageTermsKey := db.indexKey("imageterms:" + utils.HashUrl(normUrl))
+
 	for term, postings := range index {
-		key := "imageindex:" + term
+		key := db.indexKey("imageindex:" + term)
 
 		members := make([]redis.Z, len(postings))
+		imageTerms := make([]any, len(postings))
 		for i, posting := range postings {
 			members[i] = redis.Z{
 				Member: posting.ImageUrl,
 				Score:  float64(posting.TermFrequency),
 			}
+			imageTerms[i] = imageTerm(term, posting.ImageUrl)
 		}
 
 		if err := db.client.ZAdd(db.ctx, key, members...).Err(); err != nil {
 			return fmt.Errorf("could not add image index to db %v", err)
 		}
+		if err := db.client.SAdd(db.ctx, imageTermsKey, imageTerms...).Err(); err != nil {
+			return fmt.Errorf("could not add image terms of %v %v", normUrl, err)
+		}
 	}
 	return nil
 }
+
+// terms never contain spaces and escaped urls don't either
+func imageTerm(term string, imageUrl string) string {
+	return term + " " + imageUrl
+}
@@ -1,10 +1,17 @@
 package database
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"slices"
 	"testing"
+	"time"
 	"utils"
+	"web_crawler/scope"
 	"web_crawler/types"
+
+	"github.com/redis/go-redis/v9"
 )
 
 func TestAddDomainGetDomain(t *testing.T) {
@@ -20,6 +27,7 @@ func TestAddDomainGetDomain(t *testing.T) {
 		LastCrawled: utils.GetTimeInt(),
 		Allowed:     []string{},
 		Disallowed:  []string{},
+		Sitemaps:    []string{},
 	}
 
 	err = db.AddDomain(domain)
@@ -54,7 +62,7 @@ func TestUrlQueue(t *testing.T) {
 	}
 
 	for _, url := range tesurls {
-		err = db.PushUrl(url)
+		err = db.PushUrl(url, 0)
 		if err != nil {
 			t.Errorf("could not push url %v %v", url, err)
 		}
@@ -72,7 +80,7 @@ func TestUrlQueue(t *testing.T) {
 
 	//ADD a url to the db and then try to re-add it to the queue
 
-	url, err := db.PopUrl()
+	url, err := db.PopUrl("test")
 	if err != nil {
 		t.Error(err)
 	}
@@ -87,7 +95,7 @@ func TestUrlQueue(t *testing.T) {
 		t.Error(err)
 	}
 
-	err = db.PushUrl(url)
+	err = db.PushUrl(url, 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -103,3 +111,1040 @@ func TestUrlQueue(t *testing.T) {
 
 	db.client.FlushAll(db.ctx)
 }
+
+func TestFrontierCrawlDelay(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	urls := []string{
+		"https://a.example/1",
+		"https://a.example/2",
+		"https://b.example/1",
+	}
+	for _, url := range urls {
+		if err = db.PushUrl(url, 0); err != nil {
+			t.Errorf("could not push url %v %v", url, err)
+		}
+	}
+
+	//one url per host, then a.example has to wait out its crawl delay
+	popped := []string{}
+	for range 3 {
+		url, err := db.PopUrl("test")
+		if err != nil {
+			t.Error(err)
+		}
+		popped = append(popped, url)
+	}
+
+	if popped[2] != "" {
+		t.Errorf("expected no ready host got %v", popped[2])
+	}
+
+	wait, err := db.NextReadyIn()
+	if err != nil {
+		t.Error(err)
+	}
+	time.Sleep(wait)
+
+	url, err := db.PopUrl("test")
+	if err != nil {
+		t.Error(err)
+	}
+	if url != "https://a.example/2" {
+		t.Errorf("expected https://a.example/2 got %v", url)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestOverdueFor(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	if err = db.PushUrl("https://a.example/1", 0); err != nil {
+		t.Error(err)
+	}
+	db.client.ZAdd(db.ctx, frontierReadyKey, redis.Z{Member: "a.example", Score: float64(time.Now().Add(-20 * time.Minute).UnixMilli())})
+	if overdue, err := db.OverdueFor(); err != nil || overdue < 20*time.Minute {
+		t.Errorf("expected a host ready for 20m got %v %v", overdue, err)
+	}
+
+	//a host waiting on its crawl delay isn't overdue
+	if err = db.ScheduleHost("a.example", time.Now().Add(time.Hour)); err != nil {
+		t.Error(err)
+	}
+	if overdue, err := db.OverdueFor(); err != nil || overdue != 0 {
+		t.Errorf("expected nothing overdue got %v %v", overdue, err)
+	}
+	if wait, err := db.NextReadyIn(); err != nil || wait < 59*time.Minute {
+		t.Errorf("expected the host to be ready in an hour got %v %v", wait, err)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestLeaseDeadLetter(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	link := "https://c.example/broken"
+	if err = db.PushUrl(link, 0); err != nil {
+		t.Error(err)
+	}
+
+	for i := range maxRetries {
+		wait, err := db.NextReadyIn()
+		if err != nil {
+			t.Error(err)
+		}
+		time.Sleep(wait)
+
+		url, err := db.PopUrl("test")
+		if err != nil {
+			t.Error(err)
+		}
+		if url != link {
+			t.Fatalf("attempt %d expected %v got %v", i, link, url)
+		}
+
+		deadLettered, err := db.FailUrl(url, "broken")
+		if err != nil {
+			t.Error(err)
+		}
+		if deadLettered != (i == maxRetries-1) {
+			t.Errorf("attempt %d dead-lettered: %v", i, deadLettered)
+		}
+	}
+
+	deadLetters, err := db.DeadLetters(0, -1)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].Url != link || deadLetters[0].Reason != "broken" {
+		t.Errorf("expected %v in dead letters got %v", link, deadLetters)
+	}
+
+	pending, err := db.PendingUrls()
+	if err != nil {
+		t.Error(err)
+	}
+	if pending != 0 {
+		t.Errorf("expected 0 pending urls got %d", pending)
+	}
+	if n := db.client.LLen(db.ctx, processingKey("test")).Val(); n != 0 {
+		t.Errorf("expected empty processing list got %d", n)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestStaleLease(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	link := "https://d.example/slow"
+	if err = db.PushUrl(link, 0); err != nil {
+		t.Error(err)
+	}
+	if url, err := db.PopUrl("a"); err != nil || url != link {
+		t.Fatalf("expected %v got %v %v", link, url, err)
+	}
+
+	//worker a took too long, its lease is reaped and handed to worker b
+	db.client.ZAdd(db.ctx, leasesKey, redis.Z{Member: link, Score: 0})
+	reaped, err := db.ReapLeases()
+	if err != nil {
+		t.Error(err)
+	}
+	if reaped != 1 {
+		t.Errorf("expected 1 reaped lease got %d", reaped)
+	}
+	wait, err := db.NextReadyIn()
+	if err != nil {
+		t.Error(err)
+	}
+	time.Sleep(wait)
+	if url, err := db.PopUrl("b"); err != nil || url != link {
+		t.Fatalf("expected %v got %v %v", link, url, err)
+	}
+
+	if err = db.AckUrl("a", link); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost acking a stale lease got %v", err)
+	}
+	if err = db.ReleaseUrl("a", link); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost releasing a stale lease got %v", err)
+	}
+
+	if owner := db.client.HGet(db.ctx, leaseOwnerKey, link).Val(); owner != "b" {
+		t.Errorf("expected lease to stay with b got %v", owner)
+	}
+	if n := db.client.LLen(db.ctx, processingKey("b")).Val(); n != 1 {
+		t.Errorf("expected 1 url in b's processing list got %d", n)
+	}
+	queued, err := db.UrlQueueLength()
+	if err != nil {
+		t.Error(err)
+	}
+	if queued != 0 {
+		t.Errorf("expected the leased url not to be requeued got %d queued", queued)
+	}
+
+	if err = db.AckUrl("b", link); err != nil {
+		t.Error(err)
+	}
+	pending, err := db.PendingUrls()
+	if err != nil {
+		t.Error(err)
+	}
+	if pending != 0 {
+		t.Errorf("expected 0 pending urls got %d", pending)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestMigrateUrlQueue(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	//the old queue was pushed on the left and popped on the right
+	db.client.LPush(db.ctx, legacyQueueKey, "https://a.example/first", "https://a.example/second", "https://b.example/")
+
+	moved, err := db.MigrateUrlQueue()
+	if err != nil {
+		t.Error(err)
+	}
+	if moved != 3 {
+		t.Errorf("expected 3 moved urls got %d", moved)
+	}
+	if n := db.client.Exists(db.ctx, legacyQueueKey).Val(); n != 0 {
+		t.Error("expected old queue to be empty")
+	}
+
+	popped := []string{}
+	for range 3 {
+		url, err := db.PopUrl("test")
+		if err != nil {
+			t.Error(err)
+		}
+		popped = append(popped, url)
+	}
+	slices.Sort(popped)
+	if !reflect.DeepEqual(popped, []string{"", "https://a.example/first", "https://b.example/"}) {
+		t.Errorf("expected both hosts to be ready got %v", popped)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestAliases(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	canonical := "https://example.com/page"
+	alias := "http://example.com/old-page"
+
+	//a page linked to the alias before it was known to redirect
+	db.client.SAdd(db.ctx, "backlinks:"+utils.HashUrl(alias), utils.HashUrl("https://example.com/"))
+	db.client.SAdd(db.ctx, "outlinks:"+utils.HashUrl("https://example.com/"), alias, "https://example.com/other")
+
+	err = db.AddAliases(canonical, []string{alias, canonical})
+	if err != nil {
+		t.Error(err)
+	}
+
+	resolved, err := db.ResolveAliases([]string{alias, "https://example.com/other"})
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(resolved, []string{canonical, "https://example.com/other"}) {
+		t.Errorf("expected alias to resolve to %v got %v", canonical, resolved)
+	}
+
+	backlinks, err := db.client.SMembers(db.ctx, "backlinks:"+utils.HashUrl(canonical)).Result()
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(backlinks, []string{utils.HashUrl("https://example.com/")}) {
+		t.Errorf("expected backlinks of alias to move to canonical got %v", backlinks)
+	}
+	outLinks := db.client.SMembers(db.ctx, "outlinks:"+utils.HashUrl("https://example.com/")).Val()
+	slices.Sort(outLinks)
+	if !reflect.DeepEqual(outLinks, []string{"https://example.com/other", canonical}) {
+		t.Errorf("expected outlinks to the alias to point at canonical got %v", outLinks)
+	}
+
+	//the alias is never queued again
+	if err = db.PushUrl(alias, 0); err != nil {
+		t.Error(err)
+	}
+	if length, _ := db.UrlQueueLength(); length != 0 {
+		t.Errorf("expected alias not to be queued got queue length %d", length)
+	}
+
+	//the canonical url still gets a crawl of its own
+	if err = db.PushUrl(canonical, 0); err != nil {
+		t.Error(err)
+	}
+	if length, _ := db.UrlQueueLength(); length != 1 {
+		t.Errorf("expected canonical to be queued got queue length %d", length)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestOriginalOf(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	content := "<html>the same page twice</html>"
+	original := types.Page{NormUrl: "https://example.com/a", Content: content}
+	if err = db.AddPage(original); err != nil {
+		t.Error(err)
+	}
+	if err = db.AddPage(types.Page{NormUrl: "https://example.com/b", Content: content}); !errors.Is(err, ErrDuplicateContent) {
+		t.Errorf("expected ErrDuplicateContent got %v", err)
+	}
+
+	res, err := db.OriginalOf(content)
+	if err != nil {
+		t.Error(err)
+	}
+	if res != original.NormUrl {
+		t.Errorf("expected %v got %v", original.NormUrl, res)
+	}
+
+	//a removed page isn't the original of anything anymore
+	if err = db.RemovePagePostings(original.NormUrl, ContentHash(content)); err != nil {
+		t.Error(err)
+	}
+	if res, _ = db.OriginalOf(content); res != "" {
+		t.Errorf("expected no original got %v", res)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestNearDuplicate(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	original := "https://example.com/a"
+	var fingerprint uint64 = 0xf0f0f0f0f0f0f0f0
+
+	if err = db.AddFingerprint(original, fingerprint); err != nil {
+		t.Error(err)
+	}
+
+	//3 bits apart in 3 different bands
+	duplicate, err := db.FindNearDuplicate("https://example.com/b", fingerprint^(1|1<<20|1<<40))
+	if err != nil {
+		t.Error(err)
+	}
+	if duplicate != original {
+		t.Errorf("expected %v as near-duplicate got %v", original, duplicate)
+	}
+
+	//a page is never a duplicate of itself
+	duplicate, err = db.FindNearDuplicate(original, fingerprint)
+	if err != nil {
+		t.Error(err)
+	}
+	if duplicate != "" {
+		t.Errorf("expected no near-duplicate got %v", duplicate)
+	}
+
+	//4 bits in one band is too far
+	duplicate, err = db.FindNearDuplicate("https://example.com/c", fingerprint^0xf)
+	if err != nil {
+		t.Error(err)
+	}
+	if duplicate != "" {
+		t.Errorf("expected no near-duplicate got %v", duplicate)
+	}
+
+	if err = db.RemoveFingerprint(original); err != nil {
+		t.Error(err)
+	}
+	duplicate, err = db.FindNearDuplicate("https://example.com/b", fingerprint)
+	if err != nil {
+		t.Error(err)
+	}
+	if duplicate != "" {
+		t.Errorf("expected removed fingerprint not to match got %v", duplicate)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestPushUrlScope(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	policy, err := scope.New(types.Scope{
+		Deny:              []string{"*.blocked.example"},
+		MaxDepth:          2,
+		MaxPagesPerDomain: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetScope(policy)
+
+	expected := []struct {
+		url    string
+		depth  int
+		reason scope.Reason
+	}{
+		{"https://a.example/1", 0, scope.ReasonInScope},
+		{"https://a.example/2", 2, scope.ReasonInScope},
+		{"https://a.example/3", 1, scope.ReasonBudget},
+		{"https://b.example/1", 3, scope.ReasonTooDeep},
+		{"https://www.blocked.example/", 0, scope.ReasonDenied},
+		//rejected again but counted once
+		{"https://www.blocked.example/", 1, scope.ReasonDenied},
+		//seen before, it isn't checked again
+		{"https://a.example/1", 3, scope.ReasonInScope},
+	}
+	for _, e := range expected {
+		err = db.PushUrl(e.url, e.depth)
+		var scopeErr *ScopeError
+		if e.reason == scope.ReasonInScope {
+			if err != nil {
+				t.Errorf("%v: expected no error got %v", e.url, err)
+			}
+			continue
+		}
+		if !errors.As(err, &scopeErr) || scopeErr.Reason != e.reason {
+			t.Errorf("%v: expected %v got %v", e.url, e.reason, err)
+		}
+	}
+
+	if length, _ := db.UrlQueueLength(); length != 2 {
+		t.Errorf("expected 2 urls queued got %d", length)
+	}
+
+	depth, err := db.GetDepth("https://a.example/2")
+	if err != nil || depth != 2 {
+		t.Errorf("expected depth 2 got %d %v", depth, err)
+	}
+
+	counts, err := db.OutOfScopeCounts()
+	if err != nil {
+		t.Error(err)
+	}
+	if counts[string(scope.ReasonBudget)] != 1 || counts[string(scope.ReasonDenied)] != 1 {
+		t.Errorf("unexpected out of scope counts %v", counts)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestSpiderTraps(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	var scopeErr *ScopeError
+	err = db.PushUrl("https://a.example/x/y/x/y/x/y", 0)
+	if !errors.As(err, &scopeErr) || scopeErr.Reason != scope.ReasonTrapRepeats {
+		t.Errorf("expected repeated segments to be a trap got %v", err)
+	}
+
+	//faceted search, every combination of filters is a new url
+	rejected := 0
+	for i := range scope.DefaultTrapLimits.ParamThrottleCombinations + 20 {
+		err = db.PushUrl(fmt.Sprintf("https://a.example/shop?filter%d=1", i), 0)
+		if errors.As(err, &scopeErr) && scopeErr.Reason == scope.ReasonTrapThrottled {
+			rejected++
+		} else if err != nil {
+			t.Error(err)
+		}
+	}
+	if rejected == 0 {
+		t.Error("expected query parameter explosion to be throttled")
+	}
+
+	//a calendar that renders the same empty page for every day
+	for i := range scope.DefaultTrapLimits.TemplateBlockDuplicates {
+		if err = db.RecordDuplicate(fmt.Sprintf("https://a.example/calendar/%d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	err = db.PushUrl("https://a.example/calendar/2077", 0)
+	if !errors.As(err, &scopeErr) || scopeErr.Reason != scope.ReasonTrapBlocked {
+		t.Errorf("expected calendar to be blocked got %v", err)
+	}
+
+	traps, err := db.SpiderTraps()
+	if err != nil {
+		t.Error(err)
+	}
+	states := map[string]string{}
+	for _, trap := range traps {
+		states[trap.Template] = trap.State
+	}
+	if states["a.example/calendar/{n}"] != trapBlocked || states["a.example/shop?*"] != trapThrottled || len(traps) != 2 {
+		t.Errorf("unexpected spider traps %v", traps)
+	}
+
+	if err = db.ClearSpiderTrap("a.example/calendar/{n}"); err != nil {
+		t.Error(err)
+	}
+	if err = db.PushUrl("https://a.example/calendar/2077", 0); err != nil {
+		t.Errorf("expected cleared template to be admitted got %v", err)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestTrapLimits(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	policy, err := scope.New(types.Scope{
+		Traps: types.Traps{
+			Hosts: []types.HostTrapLimits{
+				{Host: "news.example", TrapLimits: types.TrapLimits{TemplateThrottleUrls: -1, TemplateBlockUrls: -1}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetScope(policy)
+
+	//a news site with more articles than the default limits allow for one template
+	articles := scope.DefaultTrapLimits.TemplateBlockUrls + 100
+	for i := range articles {
+		if err = db.PushUrl(fmt.Sprintf("https://news.example/article/%d", i), 0); err != nil {
+			t.Fatalf("article %d: %v", i, err)
+		}
+	}
+
+	queued, err := db.UrlQueueLength()
+	if err != nil {
+		t.Error(err)
+	}
+	if queued != articles {
+		t.Errorf("expected %d queued articles got %d", articles, queued)
+	}
+	traps, err := db.SpiderTraps()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(traps) != 0 {
+		t.Errorf("expected no spider traps got %v", traps)
+	}
+
+	//other hosts keep the default limits
+	var scopeErr *ScopeError
+	rejected := 0
+	for i := range scope.DefaultTrapLimits.TemplateThrottleUrls + 20 {
+		err = db.PushUrl(fmt.Sprintf("https://other.example/article/%d", i), 0)
+		if errors.As(err, &scopeErr) && scopeErr.Reason == scope.ReasonTrapThrottled {
+			rejected++
+		} else if err != nil {
+			t.Error(err)
+		}
+	}
+	if rejected == 0 {
+		t.Error("expected the template of another host to be throttled")
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestBlockDomain(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	for _, link := range []string{"https://a.example/1", "https://a.example/2", "https://b.example/1"} {
+		if err = db.PushUrl(link, 0); err != nil {
+			t.Error(err)
+		}
+	}
+
+	purged, err := db.BlockDomain("a.example")
+	if err != nil {
+		t.Error(err)
+	}
+	if purged != 2 {
+		t.Errorf("expected 2 urls purged got %d", purged)
+	}
+	if length, _ := db.UrlQueueLength(); length != 1 {
+		t.Errorf("expected 1 url left in the queue got %d", length)
+	}
+
+	var scopeErr *ScopeError
+	err = db.PushUrl("https://a.example/3", 0)
+	if !errors.As(err, &scopeErr) || scopeErr.Reason != scope.ReasonBlocked {
+		t.Errorf("expected blocked domain to be rejected got %v", err)
+	}
+
+	if err = db.UnblockDomain("a.example"); err != nil {
+		t.Error(err)
+	}
+	//purged urls are forgotten and can be queued again
+	if err = db.PushUrl("https://a.example/1", 0); err != nil {
+		t.Error(err)
+	}
+	if length, _ := db.HostQueueLength("a.example"); length != 1 {
+		t.Errorf("expected purged url to be queued again got %d", length)
+	}
+
+	if err = db.AddRecentError("https://b.example/1", errors.New("broken")); err != nil {
+		t.Error(err)
+	}
+	crawlErrors, err := db.RecentErrors(10)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(crawlErrors) != 1 || crawlErrors[0].Url != "https://b.example/1" || crawlErrors[0].Error != "broken" {
+		t.Errorf("unexpected recent errors %v", crawlErrors)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestSwapIndex(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	oldPage := types.Page{NormUrl: "https://example.com/old", Content: "old", OutLinks: []string{"https://example.com/a"}}
+	if err = db.AddPage(oldPage); err != nil {
+		t.Error(err)
+	}
+	if err = db.AddIndex(types.InvertedIndex{"stale": {NormUrl: oldPage.NormUrl, TermFrequency: 1}}); err != nil {
+		t.Error(err)
+	}
+	if err = db.AddDocument(types.Document{NormUrl: oldPage.NormUrl, Title: "old"}); err != nil {
+		t.Error(err)
+	}
+	//crawl state has to survive the swap
+	if err = db.PushUrl("https://example.com/queued", 0); err != nil {
+		t.Error(err)
+	}
+
+	staging, err := db.StagingIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPage := types.Page{NormUrl: "https://example.com/new", Content: "new", OutLinks: []string{"https://example.com/a"}}
+	if err = staging.AddPage(newPage); err != nil {
+		t.Error(err)
+	}
+	if err = staging.AddIndex(types.InvertedIndex{"fresh": {NormUrl: newPage.NormUrl, TermFrequency: 2}}); err != nil {
+		t.Error(err)
+	}
+	if err = staging.AddDocument(types.Document{NormUrl: newPage.NormUrl, Title: "new"}); err != nil {
+		t.Error(err)
+	}
+
+	//the live index is untouched until the swap
+	exists, err := db.DocumentExists(newPage.NormUrl)
+	if err != nil || exists {
+		t.Errorf("expected staged document not to be live %v %v", exists, err)
+	}
+
+	if err = db.SwapIndex(staging); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err = db.DocumentExists(oldPage.NormUrl)
+	if err != nil || exists {
+		t.Errorf("expected old document to be gone %v %v", exists, err)
+	}
+	exists, err = db.DocumentExists(newPage.NormUrl)
+	if err != nil || !exists {
+		t.Errorf("expected new document to be live %v %v", exists, err)
+	}
+
+	//the new version is live under its prefix and the old one is deleted
+	if prefix := db.client.Get(db.ctx, utils.IndexVersionKey).Val(); prefix != staging.indexPrefix {
+		t.Errorf("expected %v to be %v got %v", utils.IndexVersionKey, staging.indexPrefix, prefix)
+	}
+	if n := db.client.Exists(db.ctx, "index:stale").Val(); n != 0 {
+		t.Error("expected old postings to be gone")
+	}
+	if score := db.client.ZScore(db.ctx, db.indexKey("index:fresh"), newPage.NormUrl).Val(); score != 2 {
+		t.Errorf("expected new posting with score 2 got %v", score)
+	}
+	if count := db.client.Get(db.ctx, db.indexKey("domain:count")).Val(); count != "1" {
+		t.Errorf("expected domain:count of 1 got %v", count)
+	}
+
+	//outlinks:index names the live keys
+	outLinksIndex := db.client.LRange(db.ctx, db.indexKey("outlinks:index"), 0, -1).Val()
+	if len(outLinksIndex) != 1 || outLinksIndex[0] != "outlinks:"+utils.HashUrl(newPage.NormUrl) {
+		t.Errorf("unexpected outlinks:index %v", outLinksIndex)
+	}
+	backlinks := db.client.SMembers(db.ctx, db.indexKey("backlinks:"+utils.HashUrl("https://example.com/a"))).Val()
+	if len(backlinks) != 1 || backlinks[0] != utils.HashUrl(newPage.NormUrl) {
+		t.Errorf("expected only the new page as backlink got %v", backlinks)
+	}
+
+	if n := db.client.Keys(db.ctx, "index:*").Val(); len(n) != 0 {
+		t.Errorf("expected no unversioned keys left got %v", n)
+	}
+
+	//the next reindex gets a version of its own and the one after it deletes this one
+	next, err := db.StagingIndex()
+	if err != nil || next.indexPrefix == staging.indexPrefix {
+		t.Errorf("expected a new version got %v %v", next, err)
+	}
+	if err = db.SwapIndex(next); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.client.Keys(db.ctx, staging.indexPrefix+"*").Val(); len(n) != 0 {
+		t.Errorf("expected the replaced version to be deleted got %v", n)
+	}
+	if queued, err := db.UrlQueueLength(); err != nil || queued != 1 {
+		t.Errorf("expected the frontier to survive the swap got %v %v", queued, err)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestFieldIndex(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	page := "https://osu.ppy.sh/wiki/en/Beatmap"
+	err = db.AddIndex(types.InvertedIndex{"beatmap": {
+		NormUrl:       page,
+		TermFrequency: 6,
+		Fields:        map[string]int{utils.FieldTitle: 1, utils.FieldBody: 4, utils.FieldUrl: 1},
+		Positions:     []int{0, 12, 13, 40, 41, 90},
+	}})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if score := db.client.ZScore(db.ctx, "index:beatmap", page).Val(); score != 6 {
+		t.Errorf("expected total frequency 6 got %v", score)
+	}
+	if score := db.client.ZScore(db.ctx, "fieldindex:body:beatmap", page).Val(); score != 4 {
+		t.Errorf("expected body frequency 4 got %v", score)
+	}
+	if n := db.client.Exists(db.ctx, "fieldindex:heading:beatmap").Val(); n != 0 {
+		t.Error("expected no heading posting")
+	}
+	positions, err := utils.DecodePositions(db.client.HGet(db.ctx, "positions:beatmap", page).Val())
+	if err != nil || !reflect.DeepEqual(positions, []int{0, 12, 13, 40, 41, 90}) {
+		t.Errorf("expected stored positions got %v %v", positions, err)
+	}
+
+	if err = db.AddImageIndex(page, types.ImageIndex{"beatmap": {{ImageUrl: "https://osu.ppy.sh/beatmap.png", TermFrequency: 2}}}); err != nil {
+		t.Error(err)
+	}
+	if err = db.AddDocument(types.Document{NormUrl: page, Title: "Beatmap", Length: 6, Lang: "en"}); err != nil {
+		t.Error(err)
+	}
+
+	if err = db.RemovePagePostings(page, ""); err != nil {
+		t.Error(err)
+	}
+	if keys := db.client.Keys(db.ctx, "*:beatmap").Val(); len(keys) != 0 {
+		t.Errorf("expected postings of every field to be removed got %v", keys)
+	}
+	if keys := db.client.Keys(db.ctx, "*:"+utils.HashUrl(page)).Val(); len(keys) != 0 {
+		t.Errorf("expected the document and its terms to be removed got %v", keys)
+	}
+	if n := db.client.SCard(db.ctx, "lang:en").Val(); n != 0 {
+		t.Errorf("expected the page to leave its language got %v", n)
+	}
+	if count := db.client.Get(db.ctx, "domain:count").Val(); count != "0" {
+		t.Errorf("expected domain:count 0 got %v", count)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestAnchors(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	target := "https://osu.ppy.sh/beatmaps/editor"
+	anchorKey := "fieldindex:" + utils.FieldAnchor + ":editor"
+
+	//one host linking from every page only counts maxAnchorsPerHost times
+	for i := range maxAnchorsPerHost + 2 {
+		source := fmt.Sprintf("https://forum.example.com/t/%d", i)
+		if err = db.AddAnchors(source, map[string]map[string]int{target: {"editor": 1}}); err != nil {
+			t.Error(err)
+		}
+	}
+	if err = db.AddAnchors("https://blog.example.org/post", map[string]map[string]int{target: {"editor": 1, "beatmap": 1}}); err != nil {
+		t.Error(err)
+	}
+	//links to itself are skipped
+	if err = db.AddAnchors(target, map[string]map[string]int{target: {"editor": 1}}); err != nil {
+		t.Error(err)
+	}
+
+	if score := db.client.ZScore(db.ctx, anchorKey, target).Val(); score != maxAnchorsPerHost+1 {
+		t.Errorf("expected capped anchor frequency %v got %v", maxAnchorsPerHost+1, score)
+	}
+	if length := db.client.ZScore(db.ctx, anchorLengthKey, target).Val(); length != maxAnchorsPerHost+2 {
+		t.Errorf("expected anchor length %v got %v", maxAnchorsPerHost+2, length)
+	}
+
+	//removing links over the cap changes nothing, below it they count again
+	for i := range 3 {
+		if err = db.RemoveAnchors(fmt.Sprintf("https://forum.example.com/t/%d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	if score := db.client.ZScore(db.ctx, anchorKey, target).Val(); score != 3 {
+		t.Errorf("expected anchor frequency 3 got %v", score)
+	}
+
+	if err = db.RemoveAnchors("https://blog.example.org/post"); err != nil {
+		t.Error(err)
+	}
+	if n := db.client.Exists(db.ctx, "fieldindex:"+utils.FieldAnchor+":beatmap").Val(); n != 0 {
+		t.Error("expected postings without links left to be removed")
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestDocumentLanguage(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	url := "https://example.com/guide"
+	if err = db.AddDocument(types.Document{NormUrl: url, Title: "guide", Lang: "fr"}); err != nil {
+		t.Error(err)
+	}
+	if lang := db.client.HGet(db.ctx, "document:"+utils.HashUrl(url), "lang").Val(); lang != "fr" {
+		t.Errorf("expected language fr got %q", lang)
+	}
+	if !db.client.SIsMember(db.ctx, "lang:fr", url).Val() {
+		t.Error("expected document in the set of its language")
+	}
+
+	//a recrawl in another language moves it
+	if err = db.AddDocument(types.Document{NormUrl: url, Title: "guide", Lang: "en"}); err != nil {
+		t.Error(err)
+	}
+	if db.client.SIsMember(db.ctx, "lang:fr", url).Val() || !db.client.SIsMember(db.ctx, "lang:en", url).Val() {
+		t.Error("expected document to move to its new language")
+	}
+
+	//an unknown language is in no set
+	if err = db.AddDocument(types.Document{NormUrl: url, Title: "guide"}); err != nil {
+		t.Error(err)
+	}
+	if db.client.SIsMember(db.ctx, "lang:en", url).Val() {
+		t.Error("expected document without language to be in no set")
+	}
+	if count := db.client.Get(db.ctx, "domain:count").Val(); count != "1" {
+		t.Errorf("expected document to be counted once got %v", count)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestFetchStatus(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	link := "https://example.com/missing"
+	if err = db.SetFetchStatus(link, 404); err != nil {
+		t.Error(err)
+	}
+
+	state, err := db.GetFetchState(link)
+	if err != nil {
+		t.Error(err)
+	}
+	if state.LastFetched != 0 || state.StatusCode != 404 {
+		t.Errorf("expected a never fetched url with status 404 got %+v", state)
+	}
+	//a failed fetch doesn't make a document
+	if n := db.client.Exists(db.ctx, "document:"+utils.HashUrl(link)).Val(); n != 0 {
+		t.Error("expected no document hash")
+	}
+
+	state.LastFetched = 100
+	state.Interval = 60
+	if err = db.SetFetchState(state); err != nil {
+		t.Error(err)
+	}
+	if err = db.SetFetchStatus(link, 200); err != nil {
+		t.Error(err)
+	}
+	state, err = db.GetFetchState(link)
+	if err != nil {
+		t.Error(err)
+	}
+	if state.LastFetched != 100 || state.StatusCode != 200 {
+		t.Errorf("expected status next to the fetch state got %+v", state)
+	}
+
+	//a page that is gone keeps when it was fetched but not what it was
+	state.ContentHash = "abc"
+	state.ETag = `"v1"`
+	if err = db.SetFetchState(state); err != nil {
+		t.Error(err)
+	}
+	if err = db.ForgetFetchedContent(link); err != nil {
+		t.Error(err)
+	}
+	state, err = db.GetFetchState(link)
+	if err != nil {
+		t.Error(err)
+	}
+	if state.LastFetched != 100 || state.ContentHash != "" || state.ETag != "" {
+		t.Errorf("expected content of the fetch to be forgotten got %+v", state)
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestRecrawlRetry(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	link := "https://e.example/stale"
+	now := time.Now().Unix()
+	state := types.FetchState{NormUrl: link, LastFetched: now - 100, Interval: 60}
+	if err = db.SetFetchState(state); err != nil {
+		t.Error(err)
+	}
+
+	//every failed attempt pushes the recrawl further out
+	for attempt := range 2 {
+		queued, err := db.QueueDueRecrawls(10)
+		if err != nil {
+			t.Error(err)
+		}
+		if queued != 1 {
+			t.Fatalf("attempt %d expected 1 queued recrawl got %d", attempt, queued)
+		}
+
+		retryAt := db.client.ZScore(db.ctx, recrawlKey, link).Val()
+		expected := float64(now + recrawlRetryDelay<<attempt)
+		if retryAt < expected || retryAt > expected+5 {
+			t.Errorf("attempt %d expected recrawl to be retried at %v got %v", attempt, expected, retryAt)
+		}
+
+		if queued, _ = db.QueueDueRecrawls(10); queued != 0 {
+			t.Errorf("attempt %d expected a claimed recrawl not to be queued again got %d", attempt, queued)
+		}
+
+		//the fetch failed and the retry is due
+		db.client.ZAdd(db.ctx, recrawlKey, redis.Z{Member: link, Score: float64(now)})
+	}
+
+	queued, err := db.UrlQueueLength()
+	if err != nil {
+		t.Error(err)
+	}
+	if queued != 2 {
+		t.Errorf("expected the recrawl queued twice got %d", queued)
+	}
+
+	//a successful fetch schedules the next recrawl by its interval
+	state.LastFetched = now
+	if err = db.SetFetchState(state); err != nil {
+		t.Error(err)
+	}
+	if next := db.client.ZScore(db.ctx, recrawlKey, link).Val(); next != float64(now+60) {
+		t.Errorf("expected next recrawl at %d got %v", now+60, next)
+	}
+	if db.client.HExists(db.ctx, fetchKey(link), "recrawlattempts").Val() {
+		t.Error("expected attempts to be reset by a successful fetch")
+	}
+
+	db.client.FlushAll(db.ctx)
+}
+
+func TestSitemapQueue(t *testing.T) {
+	db := DataBase{}
+	err := db.Connect("localhost:6379", "0", "")
+	if err != nil {
+		t.Errorf("could not connect to db %v", err)
+	}
+
+	for _, queued := range []struct {
+		host  string
+		depth int
+	}{{"a.example", 3}, {"b.example", 2}, {"a.example", 1}, {"b.example", 5}} {
+		if err = db.QueueSitemaps(queued.host, queued.depth); err != nil {
+			t.Error(err)
+		}
+	}
+
+	//lowest depth first, a host queued twice keeps its lower depth
+	for _, expected := range []struct {
+		host  string
+		depth int
+	}{{"a.example", 1}, {"b.example", 2}, {"", 0}} {
+		host, depth, err := db.PopSitemaps()
+		if err != nil {
+			t.Error(err)
+		}
+		if host != expected.host || depth != expected.depth {
+			t.Errorf("expected %v at %d got %v at %d", expected.host, expected.depth, host, depth)
+		}
+	}
+
+	db.client.FlushAll(db.ctx)
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"utils"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The live index is kept under the prefix utils.IndexVersionKey holds, none
+// for an index from before versions. A reindex writes a complete new version
+// next to it while it keeps serving queries, then SwapIndex points
+// utils.IndexVersionKey at the new version and deletes the old one.
+const indexVersionCounterKey = utils.IndexVersionKey + ":next"
+
+// the version a reindex is writing, kept so one that didn't finish is cleared
+const indexStagingKey = utils.IndexVersionKey + ":staging"
+
+// keys and key patterns that make up the index, everything else is crawl state
+var indexKeys = []string{"contenthashes", contentOwnersKey, "domain:count", simhashKey, anchorLengthKey}
+var indexPatterns = []string{"index:*", "fieldindex:*", "positions:*", "anchorcount:*", "anchorsfrom:*", "terms:*", "document:*", "lang:*", "imageindex:*", "imageterms:*", "outlinks:*", "backlinks:*", simhashKey + ":*"}
+
+// how many keys are deleted or scanned per call
+const keyBatch = 1000
+
+// how long the prefix of the live index is used before it is read again,
+// a swapped in index reaches the crawlers within it
+const liveIndexTTL = time.Second
+
+// the prefix of the live index, shared by the copies of a DataBase
+type liveIndex struct {
+	mu     sync.Mutex
+	prefix string
+	read   time.Time
+}
+
+func (db *DataBase) indexKey(key string) string {
+	if db.live == nil {
+		return db.indexPrefix + key
+	}
+	return db.livePrefix() + key
+}
+
+func (db *DataBase) livePrefix() string {
+	db.live.mu.Lock()
+	defer db.live.mu.Unlock()
+
+	if time.Since(db.live.read) < liveIndexTTL {
+		return db.live.prefix
+	}
+	prefix, err := db.client.Get(db.ctx, utils.IndexVersionKey).Result()
+	if err != nil && err != redis.Nil {
+		//keeps writing to the index it knows of
+		log.Printf("could not read %v %v\n", utils.IndexVersionKey, err)
+		return db.live.prefix
+	}
+	db.live.prefix = prefix
+	db.live.read = time.Now()
+	return prefix
+}
+
+// StagingIndex returns a DataBase that writes the next version of the index.
+// Everything but the index still goes to the live keys. What a reindex that
+// didn't finish left in its version is cleared first.
+func (db *DataBase) StagingIndex() (*DataBase, error) {
+	prefix, err := db.client.Get(db.ctx, indexStagingKey).Result()
+	if err == redis.Nil {
+		version, err := db.client.Incr(db.ctx, indexVersionCounterKey).Result()
+		if err != nil {
+			return nil, fmt.Errorf("could not get the next index version %v", err)
+		}
+		prefix = fmt.Sprintf("v%d:", version)
+		if err = db.client.Set(db.ctx, indexStagingKey, prefix, 0).Err(); err != nil {
+			return nil, fmt.Errorf("could not set %v %v", indexStagingKey, err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("could not get %v %v", indexStagingKey, err)
+	}
+
+	staging := db.pinnedIndex(prefix)
+	if err = staging.ClearIndex(); err != nil {
+		return nil, err
+	}
+	return staging, nil
+}
+
+// a DataBase whose index is always the one under prefix
+func (db *DataBase) pinnedIndex(prefix string) *DataBase {
+	pinned := *db
+	pinned.live = nil
+	pinned.indexPrefix = prefix
+	return &pinned
+}
+
+// ClearIndex deletes every key of db's index in batches
+func (db *DataBase) ClearIndex() error {
+	keys, err := db.scanIndex()
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(keys); start += keyBatch {
+		if err = db.client.Del(db.ctx, keys[start:min(start+keyBatch, len(keys))]...).Err(); err != nil {
+			return fmt.Errorf("could not clear index %v", err)
+		}
+	}
+	return nil
+}
+
+// SwapIndex makes the index staged in staging the live one. Readers switch
+// from the old to the new version at once, the old version is deleted after.
+func (db *DataBase) SwapIndex(staging *DataBase) error {
+	if staging.live != nil || db.live == nil {
+		return fmt.Errorf("can only swap a staging index into the live one")
+	}
+
+	pipe := db.client.TxPipeline()
+	old := pipe.Get(db.ctx, utils.IndexVersionKey)
+	pipe.Set(db.ctx, utils.IndexVersionKey, staging.indexPrefix, 0)
+	pipe.Del(db.ctx, indexStagingKey)
+	if _, err := pipe.Exec(db.ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("could not swap in staged index %v", err)
+	}
+
+	db.live.mu.Lock()
+	db.live.prefix = staging.indexPrefix
+	db.live.read = time.Now()
+	db.live.mu.Unlock()
+
+	if err := db.pinnedIndex(old.Val()).ClearIndex(); err != nil {
+		return fmt.Errorf("could not delete the old index %v", err)
+	}
+	return nil
+}
+
+// returns every existing key of db's index
+func (db *DataBase) scanIndex() ([]string, error) {
+	keys := []string{}
+
+	for _, key := range indexKeys {
+		n, err := db.client.Exists(db.ctx, db.indexKey(key)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("could not check %v %v", db.indexKey(key), err)
+		}
+		if n > 0 {
+			keys = append(keys, db.indexKey(key))
+		}
+	}
+
+	//scan can return a key more than once
+	seen := map[string]bool{}
+	for _, pattern := range indexPatterns {
+		iter := db.client.Scan(db.ctx, 0, db.indexKey(pattern), keyBatch).Iterator()
+		for iter.Next(db.ctx) {
+			if !seen[iter.Val()] {
+				seen[iter.Val()] = true
+				keys = append(keys, iter.Val())
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("could not scan %v %v", db.indexKey(pattern), err)
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"web_crawler/scope"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// depth holds how many links away from a seed every admitted url was found,
+// domainpages how many urls of each host were admitted for the per domain budget.
+// rejected urls and why are kept in outofscope with a tally of the urls per reason in outofscope:count.
+const depthKey = "depth"
+const domainPagesKey = "domainpages"
+const outOfScopeKey = "outofscope"
+
+// ScopeError is returned for urls the scope policy rejects
+type ScopeError struct {
+	Url    string
+	Reason scope.Reason
+}
+
+func (e *ScopeError) Error() string {
+	return fmt.Sprintf("out of scope (%v): %v", e.Reason, e.Url)
+}
+
+// SetScope makes the frontier reject urls outside of policy, nil accepts everything
+func (db *DataBase) SetScope(policy *scope.Policy) {
+	db.scope = policy
+}
+
+// CheckScope records and returns a *ScopeError if normUrl is out of scope at depth
+func (db *DataBase) CheckScope(normUrl string, depth int) error {
+	blocked, err := db.DomainBlocked(hostFromUrl(normUrl))
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return db.rejectUrl(normUrl, scope.ReasonBlocked)
+	}
+
+	if db.scope == nil {
+		return nil
+	}
+
+	if reason := db.scope.Check(normUrl, depth); reason != scope.ReasonInScope {
+		return db.rejectUrl(normUrl, reason)
+	}
+	return nil
+}
+
+func (db *DataBase) rejectUrl(normUrl string, reason scope.Reason) error {
+	added, err := db.client.HSet(db.ctx, outOfScopeKey, normUrl, string(reason)).Result()
+	if err != nil {
+		return fmt.Errorf("could not record out of scope url: %v %v", normUrl, err)
+	}
+	//a url found again is only counted once
+	if added > 0 {
+		if err = db.client.HIncrBy(db.ctx, outOfScopeKey+":count", string(reason), 1).Err(); err != nil {
+			return fmt.Errorf("could not count out of scope url: %v %v", normUrl, err)
+		}
+	}
+	return &ScopeError{Url: normUrl, Reason: reason}
+}
+
+// admit checks a url against the scope and spider traps and takes a slot of its host's budget.
+// returns false without an error if the url has been seen before.
+func (db *DataBase) admit(normUrl string, depth int) (bool, error) {
+	//most links found were seen before, they don't need checking again
+	seen, err := db.client.SIsMember(db.ctx, "urlset", normUrl).Result()
+	if err != nil {
+		return false, fmt.Errorf("could not check if url %v was seen %v", normUrl, err)
+	}
+	if seen {
+		return false, nil
+	}
+
+	if err = db.CheckScope(normUrl, depth); err != nil {
+		return false, err
+	}
+	if err = db.CheckTraps(normUrl); err != nil {
+		return false, err
+	}
+
+	res, err := db.client.SAdd(db.ctx, "urlset", normUrl).Result()
+	if err != nil {
+		return false, fmt.Errorf("could not add url %v to set %v", normUrl, err)
+	}
+	if res == 0 {
+		return false, nil
+	}
+
+	//urls dropped by a trap stay in urlset so finding them again doesn't count twice
+	if err = db.countTemplate(normUrl); err != nil {
+		return false, err
+	}
+
+	if db.scope != nil && db.scope.MaxPagesPerDomain > 0 {
+		host := hostFromUrl(normUrl)
+		pages, err := db.client.HIncrBy(db.ctx, domainPagesKey, host, 1).Result()
+		if err != nil {
+			return false, fmt.Errorf("could not count pages of %v %v", host, err)
+		}
+		if pages > db.scope.MaxPagesPerDomain {
+			//forget the url so it is considered again if the budget is raised
+			pipe := db.client.TxPipeline()
+			pipe.HIncrBy(db.ctx, domainPagesKey, host, -1)
+			pipe.SRem(db.ctx, "urlset", normUrl)
+			if _, err = pipe.Exec(db.ctx); err != nil {
+				return false, fmt.Errorf("could not give back budget of %v %v", host, err)
+			}
+			return false, db.rejectUrl(normUrl, scope.ReasonBudget)
+		}
+	}
+
+	if err = db.client.HSet(db.ctx, depthKey, normUrl, depth).Err(); err != nil {
+		return false, fmt.Errorf("could not set depth of %v %v", normUrl, err)
+	}
+
+	return true, nil
+}
+
+// GetDepth returns how many links away from a seed a url was found, 0 if unknown
+func (db *DataBase) GetDepth(normUrl string) (int, error) {
+	res, err := db.client.HGet(db.ctx, depthKey, normUrl).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not get depth of %v %v", normUrl, err)
+	}
+
+	depth, err := strconv.Atoi(res)
+	if err != nil {
+		return 0, fmt.Errorf("depth could not be converted to int %v %v", res, err)
+	}
+	return depth, nil
+}
+
+// OutOfScopeCounts returns how many urls were rejected for every reason
+func (db *DataBase) OutOfScopeCounts() (map[string]int64, error) {
+	res, err := db.client.HGetAll(db.ctx, outOfScopeKey+":count").Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not get %v:count %v", outOfScopeKey, err)
+	}
+
+	counts := make(map[string]int64, len(res))
+	for reason, count := range res {
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse out of scope count %v %v", count, err)
+		}
+		counts[reason] = n
+	}
+	return counts, nil
+}
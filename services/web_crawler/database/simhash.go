@@ -0,0 +1,109 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"web_crawler/utilities"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Fingerprints of indexed pages live in simhash (url -> fingerprint) and are
+// split into simhashBands bands of 16 bits, each band value has a set of the
+// urls that share it (simhash:<band>:<value>). Two fingerprints at most
+// simhashBands-1 bits apart always share a band, so only urls in the same
+// band sets have to be compared.
+const simhashKey = "simhash"
+const simhashBands = 4
+
+// fingerprints at most this many bits apart are near-duplicates
+const maxSimhashDistance = 3
+
+func (db *DataBase) simhashBandKeys(fingerprint uint64) []string {
+	keys := make([]string, simhashBands)
+	for band := range simhashBands {
+		value := (fingerprint >> (band * 16)) & 0xffff
+		keys[band] = db.indexKey(fmt.Sprintf("%v:%d:%04x", simhashKey, band, value))
+	}
+	return keys
+}
+
+// AddFingerprint stores the fingerprint of an indexed page
+func (db *DataBase) AddFingerprint(normUrl string, fingerprint uint64) error {
+	if err := db.RemoveFingerprint(normUrl); err != nil {
+		return err
+	}
+
+	pipe := db.client.TxPipeline()
+	pipe.HSet(db.ctx, db.indexKey(simhashKey), normUrl, strconv.FormatUint(fingerprint, 16))
+	for _, key := range db.simhashBandKeys(fingerprint) {
+		pipe.SAdd(db.ctx, key, normUrl)
+	}
+	if _, err := pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not add fingerprint of %v %v", normUrl, err)
+	}
+	return nil
+}
+
+// RemoveFingerprint deletes the fingerprint of a page so it is no longer matched against
+func (db *DataBase) RemoveFingerprint(normUrl string) error {
+	res, err := db.client.HGet(db.ctx, db.indexKey(simhashKey), normUrl).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not get fingerprint of %v %v", normUrl, err)
+	}
+
+	fingerprint, err := strconv.ParseUint(res, 16, 64)
+	if err != nil {
+		return fmt.Errorf("fingerprint of %v could not be converted %v %v", normUrl, res, err)
+	}
+
+	pipe := db.client.TxPipeline()
+	pipe.HDel(db.ctx, db.indexKey(simhashKey), normUrl)
+	for _, key := range db.simhashBandKeys(fingerprint) {
+		pipe.SRem(db.ctx, key, normUrl)
+	}
+	if _, err = pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not remove fingerprint of %v %v", normUrl, err)
+	}
+	return nil
+}
+
+// FindNearDuplicate returns the indexed page closest to fingerprint that isn't normUrl,
+// empty if no page is within maxSimhashDistance bits
+func (db *DataBase) FindNearDuplicate(normUrl string, fingerprint uint64) (string, error) {
+	candidates, err := db.client.SUnion(db.ctx, db.simhashBandKeys(fingerprint)...).Result()
+	if err != nil {
+		return "", fmt.Errorf("could not get near-duplicate candidates of %v %v", normUrl, err)
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+
+	res, err := db.client.HMGet(db.ctx, db.indexKey(simhashKey), candidates...).Result()
+	if err != nil {
+		return "", fmt.Errorf("could not get fingerprints of candidates %v", err)
+	}
+
+	closest, closestDistance := "", maxSimhashDistance+1
+	for i, candidate := range candidates {
+		value, ok := res[i].(string)
+		if !ok || candidate == normUrl {
+			continue
+		}
+		candidateFingerprint, err := strconv.ParseUint(value, 16, 64)
+		if err != nil {
+			continue
+		}
+
+		distance := utilities.HammingDistance(fingerprint, candidateFingerprint)
+		//ties go to the smaller url so every crawler instance picks the same original
+		if distance < closestDistance || distance == closestDistance && candidate < closest {
+			closest, closestDistance = candidate, distance
+		}
+	}
+
+	return closest, nil
+}
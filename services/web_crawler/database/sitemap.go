@@ -0,0 +1,92 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"web_crawler/types"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// sitemap urls with at least this priority jump ahead in their host's queue
+const highSitemapPriority = 0.8
+
+// sitemapqueue is a sorted set of host -> depth of the urls found in its
+// sitemaps, hosts whose sitemaps are waiting to be read
+const sitemapQueueKey = "sitemapqueue"
+
+// QueueSitemaps has the sitemaps of host read in the background, their urls
+// are added at depth. A host that is queued already keeps the lower depth.
+func (db *DataBase) QueueSitemaps(host string, depth int) error {
+	err := db.client.ZAddLT(db.ctx, sitemapQueueKey, redis.Z{Member: host, Score: float64(depth)}).Err()
+	if err != nil {
+		return fmt.Errorf("could not queue sitemaps of %v %v", host, err)
+	}
+	return nil
+}
+
+// PopSitemaps returns the host with the lowest depth whose sitemaps are
+// waiting to be read, an empty host means none are
+func (db *DataBase) PopSitemaps() (string, int, error) {
+	res, err := db.client.ZPopMin(db.ctx, sitemapQueueKey, 1).Result()
+	if err != nil {
+		return "", 0, fmt.Errorf("could not pop %v %v", sitemapQueueKey, err)
+	}
+	if len(res) == 0 {
+		return "", 0, nil
+	}
+
+	host, ok := res[0].Member.(string)
+	if !ok {
+		return "", 0, fmt.Errorf("expected string member in %v got %T", sitemapQueueKey, res[0].Member)
+	}
+	return host, int(res[0].Score), nil
+}
+
+// AddSitemapEntry puts a new url from a sitemap in the frontier at depth. For a url
+// that was already crawled a lastmod newer than the last fetch makes it due
+// for a recrawl right away.
+func (db *DataBase) AddSitemapEntry(entry types.SitemapEntry, depth int) error {
+	admitted, err := db.admit(entry.Loc, depth)
+	if err != nil {
+		return err
+	}
+	if admitted {
+		return db.enqueue(entry.Loc, entry.Priority >= highSitemapPriority)
+	}
+
+	if entry.LastMod == 0 {
+		return nil
+	}
+
+	lastFetched, err := db.client.HGet(db.ctx, fetchKey(entry.Loc), "lastfetched").Result()
+	if err == redis.Nil {
+		//seen but not fetched yet, it is still queued
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not get last fetch of %v %v", entry.Loc, err)
+	}
+
+	fetchedAt, err := strconv.ParseInt(lastFetched, 10, 64)
+	if err != nil {
+		return fmt.Errorf("lastfetched could not be converted to int %v %v", lastFetched, err)
+	}
+	if entry.LastMod <= fetchedAt {
+		return nil
+	}
+
+	//XX only moves urls that are scheduled, LT never puts a recrawl off. a url
+	//that is queued right now waits there until its retry and may be queued twice.
+	err = db.client.ZAddArgs(db.ctx, recrawlKey, redis.ZAddArgs{
+		XX:      true,
+		LT:      true,
+		Members: []redis.Z{{Member: entry.Loc, Score: float64(time.Now().Unix())}},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("could not schedule recrawl of %v %v", entry.Loc, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"utils"
+	"web_crawler/scope"
+	"web_crawler/types"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Every admitted url is counted against its template (traps:urls) and the
+// parameter names used on its path (traps:params:<path template>). Templates
+// that grow past the trap limits of their host's scope or keep serving duplicate content
+// (traps:duplicates) are throttled or blocked. The state of a caught template
+// is in traps, why in traps:reason and when in traps:detected. traps:throttled
+// counts the urls of throttled templates to let every throttleKeepEvery-th through.
+const trapsKey = "traps"
+const trapUrlsKey = trapsKey + ":urls"
+const trapDuplicatesKey = trapsKey + ":duplicates"
+const trapThrottledKey = trapsKey + ":throttled"
+
+const (
+	trapThrottled = "throttled"
+	trapBlocked   = "blocked"
+)
+
+// a throttled template still lets one in this many new urls through
+const throttleKeepEvery = 10
+
+// tells whether count is over a trap limit, -1 is never exceeded
+func overLimit(count int64, limit int64) bool {
+	return limit >= 0 && count > limit
+}
+
+func trapParamsKey(pathTemplate string) string {
+	return trapsKey + ":params:" + utils.HashUrl(pathTemplate)
+}
+
+// the template a query parameter explosion on pathTemplate is recorded under,
+// it catches every url on that path that has a query
+func paramsTemplate(pathTemplate string) string {
+	return pathTemplate + "?*"
+}
+
+// returns the caught template a url falls under and its state, blocked wins over throttled
+func (db *DataBase) trapState(normUrl string) (string, string, error) {
+	template, pathTemplate := scope.Template(normUrl)
+	templates := []string{template}
+	if template != pathTemplate {
+		templates = append(templates, paramsTemplate(pathTemplate))
+	}
+
+	res, err := db.client.HMGet(db.ctx, trapsKey, templates...).Result()
+	if err != nil {
+		return "", "", fmt.Errorf("could not get trap state of %v %v", template, err)
+	}
+
+	trapped, state := "", ""
+	for i, value := range res {
+		if value, ok := value.(string); ok && value != "" && state != trapBlocked {
+			trapped, state = templates[i], value
+		}
+	}
+	return trapped, state, nil
+}
+
+// CheckTraps records and returns a *ScopeError if the path of normUrl is a trap or its template was blocked
+func (db *DataBase) CheckTraps(normUrl string) error {
+	if reason := scope.CheckPath(normUrl); reason != scope.ReasonInScope {
+		return db.rejectUrl(normUrl, reason)
+	}
+
+	_, state, err := db.trapState(normUrl)
+	if err != nil {
+		return err
+	}
+	if state == trapBlocked {
+		return db.rejectUrl(normUrl, scope.ReasonTrapBlocked)
+	}
+
+	return nil
+}
+
+// counts a new url against its templates and throttles or blocks them once they explode
+func (db *DataBase) countTemplate(normUrl string) error {
+	template, pathTemplate := scope.Template(normUrl)
+
+	pipe := db.client.TxPipeline()
+	urlsCmd := pipe.HIncrBy(db.ctx, trapUrlsKey, template, 1)
+	var combinationsCmd *redis.IntCmd
+	if template != pathTemplate {
+		_, params, _ := strings.Cut(template, "?")
+		pipe.SAdd(db.ctx, trapParamsKey(pathTemplate), params)
+		combinationsCmd = pipe.SCard(db.ctx, trapParamsKey(pathTemplate))
+	}
+	if _, err := pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not count template of %v %v", normUrl, err)
+	}
+
+	urls := urlsCmd.Val()
+	combinations := int64(0)
+	if combinationsCmd != nil {
+		combinations = combinationsCmd.Val()
+	}
+
+	trapped, state, err := db.trapState(normUrl)
+	if err != nil {
+		return err
+	}
+
+	limits := db.scope.TrapLimits(normUrl)
+	switch {
+	case overLimit(urls, limits.TemplateBlockUrls):
+		return db.trapUrl(normUrl, template, trapBlocked, fmt.Sprintf("over %d urls", limits.TemplateBlockUrls))
+	case overLimit(combinations, limits.ParamBlockCombinations):
+		return db.trapUrl(normUrl, paramsTemplate(pathTemplate), trapBlocked, fmt.Sprintf("over %d query parameter combinations", limits.ParamBlockCombinations))
+	case overLimit(urls, limits.TemplateThrottleUrls) && state == "":
+		return db.trapUrl(normUrl, template, trapThrottled, fmt.Sprintf("over %d urls", limits.TemplateThrottleUrls))
+	case overLimit(combinations, limits.ParamThrottleCombinations) && state == "":
+		return db.trapUrl(normUrl, paramsTemplate(pathTemplate), trapThrottled, fmt.Sprintf("over %d query parameter combinations", limits.ParamThrottleCombinations))
+	case state == trapThrottled:
+		seen, err := db.client.HIncrBy(db.ctx, trapThrottledKey, trapped, 1).Result()
+		if err != nil {
+			return fmt.Errorf("could not count throttled template %v %v", trapped, err)
+		}
+		if seen%throttleKeepEvery != 0 {
+			return db.rejectUrl(normUrl, scope.ReasonTrapThrottled)
+		}
+	}
+
+	return nil
+}
+
+// RecordDuplicate counts a page that turned out to be a duplicate against its
+// template, a template that keeps serving the same content is blocked
+func (db *DataBase) RecordDuplicate(normUrl string) error {
+	template, _ := scope.Template(normUrl)
+
+	duplicates, err := db.client.HIncrBy(db.ctx, trapDuplicatesKey, template, 1).Result()
+	if err != nil {
+		return fmt.Errorf("could not count duplicate %v %v", normUrl, err)
+	}
+	limit := db.scope.TrapLimits(normUrl).TemplateBlockDuplicates
+	if limit < 0 || duplicates < limit {
+		return nil
+	}
+
+	return db.setTrap(template, trapBlocked, fmt.Sprintf("%d pages with duplicate content", duplicates))
+}
+
+// records the trap and rejects the url that tripped it
+func (db *DataBase) trapUrl(normUrl string, template string, state string, reason string) error {
+	if err := db.setTrap(template, state, reason); err != nil {
+		return err
+	}
+
+	if state == trapBlocked {
+		return db.rejectUrl(normUrl, scope.ReasonTrapBlocked)
+	}
+	return db.rejectUrl(normUrl, scope.ReasonTrapThrottled)
+}
+
+func (db *DataBase) setTrap(template string, state string, reason string) error {
+	current, err := db.client.HGet(db.ctx, trapsKey, template).Result()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("could not get trap state of %v %v", template, err)
+	}
+	//a blocked template is never only throttled again
+	if current == state || current == trapBlocked {
+		return nil
+	}
+
+	pipe := db.client.TxPipeline()
+	pipe.HSet(db.ctx, trapsKey, template, state)
+	pipe.HSet(db.ctx, trapsKey+":reason", template, reason)
+	pipe.HSet(db.ctx, trapsKey+":detected", template, time.Now().Unix())
+	if _, err = pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not record trap %v %v", template, err)
+	}
+	return nil
+}
+
+// SpiderTraps returns every template that was throttled or blocked
+func (db *DataBase) SpiderTraps() ([]types.SpiderTrap, error) {
+	pipe := db.client.Pipeline()
+	statesCmd := pipe.HGetAll(db.ctx, trapsKey)
+	reasonsCmd := pipe.HGetAll(db.ctx, trapsKey+":reason")
+	detectedCmd := pipe.HGetAll(db.ctx, trapsKey+":detected")
+	if _, err := pipe.Exec(db.ctx); err != nil {
+		return nil, fmt.Errorf("could not get spider traps %v", err)
+	}
+
+	traps := []types.SpiderTrap{}
+	for template, state := range statesCmd.Val() {
+		detected, _ := strconv.ParseInt(detectedCmd.Val()[template], 10, 64)
+		traps = append(traps, types.SpiderTrap{
+			Template: template,
+			State:    state,
+			Reason:   reasonsCmd.Val()[template],
+			Detected: detected,
+		})
+	}
+
+	return traps, nil
+}
+
+// ClearSpiderTrap lifts a throttle or block and restarts the counts of a template
+func (db *DataBase) ClearSpiderTrap(template string) error {
+	pipe := db.client.TxPipeline()
+	pipe.HDel(db.ctx, trapsKey, template)
+	pipe.HDel(db.ctx, trapsKey+":reason", template)
+	pipe.HDel(db.ctx, trapsKey+":detected", template)
+	pipe.HDel(db.ctx, trapUrlsKey, template)
+	pipe.HDel(db.ctx, trapDuplicatesKey, template)
+	pipe.HDel(db.ctx, trapThrottledKey, template)
+	if pathTemplate, ok := strings.CutSuffix(template, "?*"); ok {
+		pipe.Del(db.ctx, trapParamsKey(pathTemplate))
+	}
+	if _, err := pipe.Exec(db.ctx); err != nil {
+		return fmt.Errorf("could not clear trap %v %v", template, err)
+	}
+	return nil
+}
@@ -3,10 +3,11 @@ package handlers
 import (
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
-	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -135,9 +136,31 @@ const (
 	ReasonAllowed
 	ReasonDisallowed
 	ReasonFailed
+	// robots.txt couldn't be fetched, nothing may be crawled until it can
+	ReasonRobotsUnavailable
 )
 
+func (r Reason) String() string {
+	switch r {
+	case ReasonCrawlDelay:
+		return "crawl-delay"
+	case ReasonAllowed:
+		return "allowed"
+	case ReasonDisallowed:
+		return "disallowed"
+	case ReasonFailed:
+		return "failed"
+	case ReasonRobotsUnavailable:
+		return "robots-unavailable"
+	}
+	return "unknown"
+}
+
 func CanCrawl(rawUrl string, domain types.Domain) (bool, Reason, error) {
+	if domain.RobotsUnavailable {
+		return false, ReasonRobotsUnavailable, nil
+	}
+
 	rs, err := newRuleSet(domain.Allowed, domain.Disallowed)
 	if err != nil {
 		return false, ReasonFailed, err
@@ -154,112 +177,215 @@ func CanCrawl(rawUrl string, domain types.Domain) (bool, Reason, error) {
 	return true, ReasonAllowed, nil
 }
 
-func downloadRobots(domainName string) ([]string, error) {
+// RFC 9309 limits
+const maxRobotsBytes = 500 << 10
+const maxRobotsRedirects = 5
+
+// robots.txt is fetched again after this, the RFC asks for at most a day
+const robotsTTL = 24 * time.Hour
+
+// a robots.txt that couldn't be fetched disallows everything, so it is retried sooner
+const robotsUnavailableTTL = 1 * time.Hour
+
+// sent with every request and matched against robots.txt groups, set from the config in main
+var UserAgent = "OrbBot/1.0"
+
+// how long fetching robots.txt and sitemaps may take, set from the config in main
+var RobotsTimeout = 10 * time.Second
+var SitemapTimeout = 30 * time.Second
+
+// downloads robots.txt, a non nil error means the server couldn't be reached
+func downloadRobots(domainName string) (string, int, error) {
 	url := domainName + "/robots.txt"
 	req, err := http.NewRequest("GET", url, nil)
-
 	if err != nil {
-		return nil, fmt.Errorf("error from http request to %v %v", url, err)
+		return "", 0, fmt.Errorf("error from http request to %v %v", url, err)
 	}
 
-	userAgent := os.Getenv("USER_AGENT")
-
-	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("User-Agent", UserAgent)
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := &http.Client{
+		Timeout: RobotsTimeout,
+		//past the limit the last redirect is returned and treated like a 4xx
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRobotsRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return make([]string, 0), fmt.Errorf("could not get robots from %v %v", domainName, err)
+		return "", 0, fmt.Errorf("could not get robots from %v %v", domainName, err)
 	}
 	defer resp.Body.Close()
 
-	bb, err := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", resp.StatusCode, nil
+	}
+
+	bb, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsBytes))
 	if err != nil {
-		return make([]string, 0), fmt.Errorf("could not read bytes from body %v", err)
+		return "", resp.StatusCode, fmt.Errorf("could not read bytes from body %v", err)
+	}
+
+	return string(bb), resp.StatusCode, nil
+}
+
+// a group is one or more consecutive user-agent lines and the rules after them
+type robotsGroup struct {
+	agents     []string
+	allow      []string
+	disallow   []string
+	crawlDelay int64
+}
+
+// parseRobots splits robots.txt into its groups and the sitemaps, which don't belong to any group
+func parseRobots(body string) ([]*robotsGroup, []string) {
+	groups := []*robotsGroup{}
+	sitemaps := []string{}
+
+	var group *robotsGroup
+	//a user-agent line after a rule starts a new group
+	inRules := false
+
+	for _, line := range strings.Split(body, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if group == nil || inRules {
+				group = &robotsGroup{}
+				groups = append(groups, group)
+				inRules = false
+			}
+			group.agents = append(group.agents, strings.ToLower(value))
+
+		case "allow", "disallow", "crawl-delay":
+			//rules before the first user-agent don't apply to anyone
+			if group == nil {
+				continue
+			}
+			inRules = true
+
+			//an empty path matches nothing
+			if value == "" {
+				continue
+			}
+
+			switch key {
+			case "allow":
+				group.allow = append(group.allow, value)
+			case "disallow":
+				group.disallow = append(group.disallow, value)
+			case "crawl-delay":
+				//a broken crawl-delay is ignored, it doesn't invalidate the file
+				delay, err := strconv.ParseFloat(value, 64)
+				if err == nil && delay > 0 {
+					group.crawlDelay = int64(math.Ceil(delay))
+				}
+			}
+
+		case "sitemap":
+			if value != "" {
+				sitemaps = append(sitemaps, value)
+			}
+		}
 	}
 
-	strings := make([]string, 0)
-	s := ""
-	for _, b := range bb {
-		if b == '\n' {
-			strings = append(strings, s)
-			s = ""
-		} else {
-			s += string(b)
+	return groups, sitemaps
+}
+
+// productToken is the part of UserAgent robots.txt groups are matched against,
+// "OrbBot/1.0 (+https://orb.ax)" -> "orbbot"
+func productToken(userAgent string) string {
+	token, _, _ := strings.Cut(strings.TrimSpace(userAgent), "/")
+	token, _, _ = strings.Cut(token, " ")
+	return strings.ToLower(token)
+}
+
+// selectGroup merges every group naming our product token, or every * group if none does
+func selectGroup(groups []*robotsGroup, token string) robotsGroup {
+	merge := func(agent string) (robotsGroup, bool) {
+		merged := robotsGroup{}
+		found := false
+		for _, group := range groups {
+			if !slices.Contains(group.agents, agent) {
+				continue
+			}
+			found = true
+			merged.allow = append(merged.allow, group.allow...)
+			merged.disallow = append(merged.disallow, group.disallow...)
+			if merged.crawlDelay == 0 {
+				merged.crawlDelay = group.crawlDelay
+			}
+		}
+		return merged, found
+	}
+
+	if token != "" && token != "*" {
+		if merged, found := merge(token); found {
+			return merged
 		}
 	}
 
-	return strings, nil
+	merged, _ := merge("*")
+	return merged
 }
 
-func robotsToDomain(domainName string, robotsLines []string) (types.Domain, error) {
-    domain := types.Domain{Name: domainName}
-    allow := []string{}
-    disallow := []string{}
-    isInUser := false
-    userAgent := strings.ToLower(os.Getenv("USER_AGENT"))
-
-    for _, raw := range robotsLines {
-        line := strings.TrimSpace(raw)
-        if line == "" || strings.HasPrefix(line, "#") {
-            continue
-        }
-
-        lineLower := strings.ToLower(line)
-
-        if strings.HasPrefix(lineLower, "user-agent: *") ||
-            strings.HasPrefix(lineLower, "user-agent: "+userAgent) {
-            isInUser = true
-            continue
-        }
-
-        if isInUser && strings.HasPrefix(lineLower, "user-agent:") {
-            break 
-        }
-
-        if !isInUser {
-            continue
-        }
-
-        switch {
-        case strings.HasPrefix(lineLower, "allow:"):
-            s := strings.TrimSpace(strings.TrimPrefix(line, "Allow:"))
-            allow = append(allow, s)
-
-        case strings.HasPrefix(lineLower, "disallow:"):
-            s := strings.TrimSpace(strings.TrimPrefix(line, "Disallow:"))
-            disallow = append(disallow, s)
-
-        case strings.HasPrefix(lineLower, "crawl-delay:"):
-            parts := strings.Fields(line)
-            if len(parts) > 1 {
-                cd, err := strconv.ParseInt(parts[1], 10, 64)
-                if err != nil {
-                    return types.Domain{}, fmt.Errorf("invalid crawl-delay in line %q: %v", line, err)
-                }
-                domain.CrawlDelay = cd
-            }
-        }
-    }
-
-    domain.Allowed = allow
-    domain.Disallowed = disallow
-    domain.LastCrawled = time.Now().Unix()
-
-    return domain, nil
+func robotsToDomain(domainName string, body string) types.Domain {
+	groups, sitemaps := parseRobots(body)
+	group := selectGroup(groups, productToken(UserAgent))
+
+	return types.Domain{
+		Name:          domainName,
+		CrawlDelay:    group.crawlDelay,
+		LastCrawled:   time.Now().Unix(),
+		Allowed:       group.allow,
+		Disallowed:    group.disallow,
+		Sitemaps:      sitemaps,
+		RobotsExpires: time.Now().Add(robotsTTL).Unix(),
+	}
 }
 
+// GetRobotsFromDomain fetches robots.txt and turns it into a domain.
+// If it can't be fetched the domain is still returned, disallowing
+// everything until RobotsExpires, together with the error.
 func GetRobotsFromDomain(domainName string) (types.Domain, error) {
 	u, err := url.Parse(domainName)
 	if err != nil {
-		return types.Domain{Name: u.Host}, fmt.Errorf("could not parse domain: %v Reason: %v", domainName, err)
+		return types.Domain{}, fmt.Errorf("could not parse domain: %v Reason: %v", domainName, err)
 	}
 
-	lines, err := downloadRobots(domainName)
-	if err != nil {
-		return types.Domain{Name: u.Host}, err
+	body, status, err := downloadRobots(domainName)
+
+	switch {
+	case err != nil, status >= 500:
+		//unreachable or server error means complete disallow
+		if err == nil {
+			err = fmt.Errorf("robots.txt of %v returned status %d", domainName, status)
+		}
+		return types.Domain{
+			Name:              u.Host,
+			LastCrawled:       time.Now().Unix(),
+			Disallowed:        []string{"/"},
+			RobotsUnavailable: true,
+			RobotsExpires:     time.Now().Add(robotsUnavailableTTL).Unix(),
+		}, err
+	case status >= 300:
+		//4xx and too many redirects mean there are no rules
+		return robotsToDomain(u.Host, ""), nil
 	}
 
-	return robotsToDomain(u.Host, lines)
+	return robotsToDomain(u.Host, body), nil
 }
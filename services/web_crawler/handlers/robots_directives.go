@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"web_crawler/types"
+)
+
+// BotName is the name robots.txt groups, meta robots and X-Robots-Tag address us by
+func BotName() string {
+	return productToken(UserAgent)
+}
+
+// ParseRobotsDirectives reads values like "noindex, nofollow" from meta robots tags
+// and X-Robots-Tag headers, a directive in any of them applies
+func ParseRobotsDirectives(values ...string) types.RobotsDirectives {
+	directives := types.RobotsDirectives{}
+
+	for _, value := range values {
+		for directive := range strings.SplitSeq(value, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "noindex":
+				directives.NoIndex = true
+			case "nofollow":
+				directives.NoFollow = true
+			case "noarchive":
+				directives.NoArchive = true
+			case "none":
+				directives.NoIndex = true
+				directives.NoFollow = true
+			}
+		}
+	}
+
+	return directives
+}
+
+// XRobotsTagValues returns the X-Robots-Tag values meant for every crawler or for botName.
+// "otherbot: noindex" is skipped, "unavailable_after: ..." is a directive and not a bot.
+func XRobotsTagValues(header http.Header, botName string) []string {
+	values := []string{}
+
+	for _, value := range header.Values("X-Robots-Tag") {
+		prefix, rest, found := strings.Cut(value, ":")
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+
+		if !found || strings.Contains(prefix, ",") || prefix == "unavailable_after" {
+			values = append(values, value)
+		} else if prefix == botName {
+			values = append(values, rest)
+		}
+	}
+
+	return values
+}
@@ -2,7 +2,12 @@ package handlers
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 	"web_crawler/types"
 )
 
@@ -54,3 +59,133 @@ func TestCanCrawl(t *testing.T) {
 		}
 	}
 }
+
+func TestRobotsGroups(t *testing.T) {
+	setUserAgent(t, "OrbBot/1.0 (+https://orb.ax)")
+
+	robots := strings.Join([]string{
+		"User-agent: *",
+		"Disallow: /",
+		"",
+		"# consecutive user-agent lines share one group",
+		"User-agent: googlebot",
+		"User-agent: orbbot",
+		"Disallow: /private # comment",
+		"Allow: /private/public",
+		"Disallow:",
+		"Crawl-delay: not a number",
+		"",
+		"User-agent: otherbot",
+		"Disallow: /other",
+		"",
+		"# groups for the same agent are merged",
+		"user-agent: ORBBOT",
+		"Disallow: /tmp",
+		"Crawl-delay: 2.5",
+	}, "\r\n")
+
+	domain := robotsToDomain("example.com", robots)
+
+	expectedDisallow := []string{"/private", "/tmp"}
+	if !reflect.DeepEqual(domain.Disallowed, expectedDisallow) {
+		t.Errorf("expected disallowed %v got %v", expectedDisallow, domain.Disallowed)
+	}
+	if !reflect.DeepEqual(domain.Allowed, []string{"/private/public"}) {
+		t.Errorf("expected allowed [/private/public] got %v", domain.Allowed)
+	}
+	if domain.CrawlDelay != 3 {
+		t.Errorf("expected crawl delay 3 got %d", domain.CrawlDelay)
+	}
+
+	domain.LastCrawled = 0
+	expected := map[string]bool{
+		"https://example.com/":                    true,
+		"https://example.com/private/x":           false,
+		"https://example.com/private/public/page": true,
+		"https://example.com/other":               true,
+		"https://example.com/tmp/file":            false,
+	}
+	for link, allowed := range expected {
+		res, _, err := CanCrawl(link, domain)
+		if err != nil {
+			t.Error(err)
+		}
+		if res != allowed {
+			t.Errorf("%v expected %v got %v", link, allowed, res)
+		}
+	}
+}
+
+func TestRobotsFallsBackToStar(t *testing.T) {
+	setUserAgent(t, "OrbBot")
+
+	domain := robotsToDomain("example.com", "User-agent: otherbot\nDisallow: /\n\nUser-agent: *\nDisallow: /search\n")
+	if !reflect.DeepEqual(domain.Disallowed, []string{"/search"}) {
+		t.Errorf("expected the * group got %v", domain.Disallowed)
+	}
+}
+
+func TestRobotsStatusCodes(t *testing.T) {
+	status := http.StatusOK
+	body := "User-agent: *\nDisallow: /private\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	domain, err := GetRobotsFromDomain(server.URL)
+	if err != nil || len(domain.Disallowed) != 1 || domain.RobotsExpires <= time.Now().Unix() {
+		t.Errorf("expected rules from 200 got %+v %v", domain, err)
+	}
+
+	status = http.StatusNotFound
+	domain, err = GetRobotsFromDomain(server.URL)
+	if err != nil || len(domain.Disallowed) != 0 || domain.RobotsUnavailable {
+		t.Errorf("expected allow all for 404 got %+v %v", domain, err)
+	}
+
+	status = http.StatusServiceUnavailable
+	domain, err = GetRobotsFromDomain(server.URL)
+	if err == nil || !domain.RobotsUnavailable {
+		t.Errorf("expected disallow all for 503 got %+v %v", domain, err)
+	}
+	if res, reason, _ := CanCrawl(server.URL+"/", domain); res || reason != ReasonRobotsUnavailable {
+		t.Errorf("expected robots unavailable got %v %v", res, reason)
+	}
+
+	//only the first 500 KiB are parsed
+	status = http.StatusOK
+	body = "User-agent: *\n" + strings.Repeat("#", maxRobotsBytes) + "\nDisallow: /late\n"
+	domain, err = GetRobotsFromDomain(server.URL)
+	if err != nil || len(domain.Disallowed) != 0 {
+		t.Errorf("expected rules past the size cap to be ignored got %+v %v", domain, err)
+	}
+}
+
+func TestRobotsDirectives(t *testing.T) {
+	header := http.Header{}
+	header.Add("X-Robots-Tag", "otherbot: noindex")
+	header.Add("X-Robots-Tag", "orbbot: noarchive")
+	header.Add("X-Robots-Tag", "unavailable_after: 25 Jun 2010 15:00:00 PST")
+
+	values := XRobotsTagValues(header, "orbbot")
+	directives := ParseRobotsDirectives(values...)
+	if directives.NoIndex || directives.NoFollow || !directives.NoArchive {
+		t.Errorf("expected only noarchive got %+v from %v", directives, values)
+	}
+
+	directives = ParseRobotsDirectives("index, follow", "NONE")
+	if !directives.NoIndex || !directives.NoFollow {
+		t.Errorf("expected none to mean noindex, nofollow got %+v", directives)
+	}
+}
+
+func setUserAgent(t *testing.T, userAgent string) {
+	previous := UserAgent
+	UserAgent = userAgent
+	t.Cleanup(func() { UserAgent = previous })
+}
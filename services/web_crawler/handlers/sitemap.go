@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"web_crawler/types"
+
+	"golang.org/x/net/html/charset"
+)
+
+// limits from the sitemap protocol, per sitemap file
+const maxSitemapBytes = 50 << 20
+const maxSitemapUrls = 50000
+
+// how many sitemap files are fetched for one domain, index files included
+const maxSitemapsPerDomain = 20
+
+const defaultSitemapPriority = 0.5
+
+// both <urlset> and <sitemapindex> decode into this, namespaces are ignored
+type sitemapXml struct {
+	Urls     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc      string `xml:"loc"`
+	LastMod  string `xml:"lastmod"`
+	Priority string `xml:"priority"`
+}
+
+func downloadSitemap(sitemapUrl string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", sitemapUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error from http request to %v %v", sitemapUrl, err)
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+
+	client := &http.Client{Timeout: SitemapTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not get sitemap %v %v", sitemapUrl, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("could not get sitemap %v status %d", sitemapUrl, resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
+
+// parseSitemap decodes a sitemap or sitemap index, gzipped or not
+func parseSitemap(body io.Reader) (sitemapXml, error) {
+	reader := bufio.NewReader(io.LimitReader(body, maxSitemapBytes))
+
+	//.xml.gz files are often served without Content-Encoding, go by the magic bytes
+	magic, _ := reader.Peek(2)
+	var r io.Reader = reader
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return sitemapXml{}, fmt.Errorf("could not read gzipped sitemap %v", err)
+		}
+		defer gz.Close()
+		r = io.LimitReader(gz, maxSitemapBytes)
+	}
+
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	var sitemap sitemapXml
+	if err := decoder.Decode(&sitemap); err != nil {
+		return sitemapXml{}, fmt.Errorf("could not decode sitemap %v", err)
+	}
+
+	return sitemap, nil
+}
+
+var lastModLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// lastmod is a W3C datetime, anything else is ignored
+func parseLastMod(value string) int64 {
+	value = strings.TrimSpace(value)
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Unix()
+		}
+	}
+	return 0
+}
+
+func parsePriority(value string) float64 {
+	priority, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || priority < 0 || priority > 1 {
+		return defaultSitemapPriority
+	}
+	return priority
+}
+
+// GetSitemapEntries fetches the given sitemaps and every sitemap their index
+// files point to. Entries from sitemaps that could be read are returned even
+// if others failed.
+func GetSitemapEntries(sitemapUrls []string) ([]types.SitemapEntry, error) {
+	entries := make([]types.SitemapEntry, 0)
+	var errs []error
+
+	queue := append([]string{}, sitemapUrls...)
+	seen := make(map[string]bool)
+	fetched := 0
+
+	for len(queue) > 0 && fetched < maxSitemapsPerDomain {
+		sitemapUrl := strings.TrimSpace(queue[0])
+		queue = queue[1:]
+		if sitemapUrl == "" || seen[sitemapUrl] {
+			continue
+		}
+		seen[sitemapUrl] = true
+		fetched++
+
+		body, err := downloadSitemap(sitemapUrl)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sitemap, err := parseSitemap(body)
+		body.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v %v", sitemapUrl, err))
+			continue
+		}
+
+		for _, s := range sitemap.Sitemaps {
+			queue = append(queue, s.Loc)
+		}
+
+		for i, u := range sitemap.Urls {
+			if i >= maxSitemapUrls {
+				break
+			}
+			loc := strings.TrimSpace(u.Loc)
+			if loc == "" {
+				continue
+			}
+			entries = append(entries, types.SitemapEntry{
+				Loc:      loc,
+				LastMod:  parseLastMod(u.LastMod),
+				Priority: parsePriority(u.Priority),
+			})
+		}
+	}
+
+	return entries, errors.Join(errs...)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRobotsSitemaps(t *testing.T) {
+	setUserAgent(t, "orbbot")
+
+	robots := strings.Join([]string{
+		"Sitemap: https://example.com/sitemap.xml",
+		"User-agent: *",
+		"Disallow: /private",
+		"",
+		"User-agent: otherbot",
+		"Disallow: /",
+		"sitemap: https://example.com/news.xml.gz",
+	}, "\n")
+
+	domain := robotsToDomain("example.com", robots)
+
+	if len(domain.Sitemaps) != 2 || domain.Sitemaps[1] != "https://example.com/news.xml.gz" {
+		t.Errorf("expected both sitemaps got %v", domain.Sitemaps)
+	}
+	if len(domain.Disallowed) != 1 {
+		t.Errorf("expected only the * group rules got %v", domain.Disallowed)
+	}
+}
+
+func TestGetSitemapEntries(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap_index.xml":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>` + server.URL + `/pages.xml.gz</loc></sitemap>
+	<sitemap><loc>` + server.URL + `/missing.xml</loc></sitemap>
+</sitemapindex>`))
+		case "/pages.xml.gz":
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>` + server.URL + `/a</loc><lastmod>2024-05-01</lastmod><priority>0.9</priority></url>
+	<url><loc>` + server.URL + `/b</loc></url>
+</urlset>`))
+			gz.Close()
+			w.Write(buf.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	entries, err := GetSitemapEntries([]string{server.URL + "/sitemap_index.xml"})
+	if err == nil {
+		t.Error("expected an error for the missing sitemap")
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries got %v", entries)
+	}
+
+	lastMod := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC).Unix()
+	if entries[0].Loc != server.URL+"/a" || entries[0].LastMod != lastMod || entries[0].Priority != 0.9 {
+		t.Errorf("unexpected first entry %+v", entries[0])
+	}
+	if entries[1].LastMod != 0 || entries[1].Priority != defaultSitemapPriority {
+		t.Errorf("unexpected second entry %+v", entries[1])
+	}
+}
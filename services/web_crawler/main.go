@@ -2,36 +2,126 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"math/rand"
 	"os"
 	"os/signal"
-	"sync"
+	"strconv"
 	"syscall"
 	"time"
-	"utils"
+	"web_crawler/admin"
+	"web_crawler/config"
 	"web_crawler/crawler"
 	"web_crawler/database"
+	"web_crawler/handlers"
+	"web_crawler/metrics"
+	"web_crawler/pagestore"
+	"web_crawler/scope"
+	"web_crawler/utilities"
+	"web_crawler/warc"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // asdfasdfasdf
 func main() {
 	fmt.Println(":)")
 
-	redisHost := utils.GetEnv("REDIS_HOST", "localhost")
-	redisPort := utils.GetEnv("REDIS_PORT", "6379")
-	redisPassword := utils.GetEnv("REDIS_PASSWORD", "")
-	redisDB := utils.GetEnv("REDIS_DB", "0")
+	cfg, printConfig, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			panic(err)
+		}
+		//the config is printed even if it is invalid so it can be fixed
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%v\n", err)
+		os.Exit(1)
+	}
+	//anyone who can reach it could pause the crawl or block domains
+	if cfg.Admin.Exposed() && cfg.Admin.Token == "" {
+		fmt.Fprintf(os.Stderr, "warning: the admin api on %v can be reached from other hosts and has no token\n", cfg.Admin.Addr)
+	}
+
+	crawler.MaxBodySize = cfg.Crawler.MaxBodyBytes
+	crawler.FetchTimeout = cfg.Crawler.FetchTimeout
+	handlers.UserAgent = cfg.Crawler.UserAgent
+	handlers.RobotsTimeout = cfg.Crawler.RobotsTimeout
+	handlers.SitemapTimeout = cfg.Crawler.SitemapTimeout
+	database.DefaultCrawlDelay = cfg.Crawler.DefaultCrawlDelay
+	utilities.TrackingParams = cfg.Crawler.TrackingParams
 
 	db := database.DataBase{}
-	if err := db.Connect(redisHost+":"+redisPort, redisDB, redisPassword); err != nil {
+	if err := db.Connect(cfg.Redis.Addr(), strconv.Itoa(cfg.Redis.DB), cfg.Redis.Password); err != nil {
+		panic(err)
+	}
+
+	//the scope limits what the crawl follows, validated with the config
+	policy, err := scope.New(cfg.Scope)
+	if err != nil {
+		panic(err)
+	}
+	db.SetScope(policy)
+
+	//raw pages of the index, so it can be rebuilt without crawling
+	pages, err := pagestore.New(cfg.PageStore.Kind, cfg.PageStore.Dir, &db)
+	if err != nil {
+		panic(err)
+	}
+	crawler.Pages = pages
+
+	if cfg.Reindex {
+		stats, err := crawler.Reindex(&db, pages)
+		fmt.Printf("reindexed %d pages, %d duplicates, %d failed\n", stats.Pages, stats.Duplicates, stats.Failed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	//indexes archived pages again without the network, e.g. after the parser changed
+	if cfg.Replay != "" {
+		stats, err := crawler.Replay(&db, cfg.Replay)
+		fmt.Printf("replayed %d pages from %d files, %d failed\n", stats.Pages, stats.Files, stats.Failed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	//urls queued by a crawler from before the frontier
+	moved, err := db.MigrateUrlQueue()
+	if err != nil {
 		panic(err)
 	}
+	if moved > 0 {
+		fmt.Printf("moved %d urls from the old queue into the frontier\n", moved)
+	}
 
 	//seed urls should be different urls preferably as many as the amount of crawler workers
-	seeds := []string{"https://en.wikipedia.org/wiki/Osu!", "https://osu.ppy.sh/", "https://www.wikihow.com/Play-osu!", "https://github.com/ppy/osu", "https://www.osu.edu/"}
-	for _, seed := range seeds {
-		err := db.PushUrl(seed)
+	for _, seed := range policy.Seeds {
+		normUrl, err := utilities.CanonicalizeUrl(seed)
+		if err != nil || normUrl == "" {
+			panic(fmt.Sprintf("invalid seed url: %v %v", seed, err))
+		}
+		//blocked domains and spent budgets are only known to the frontier
+		var scopeErr *database.ScopeError
+		err = db.PushUrl(normUrl, 0)
+		if errors.As(err, &scopeErr) {
+			fmt.Printf("skipping seed url: %v\n", scopeErr)
+			continue
+		}
 		if err != nil {
 			panic(err)
 		}
@@ -48,22 +138,48 @@ func main() {
 		cancel()
 	}()
 
-	numWorkers := 5
-	var wg sync.WaitGroup
-	wg.Add(numWorkers)
+	//puts urls of crashed or hung workers back in the queue
+	go crawler.StartReaper(ctx, &db)
+	//puts pages that are due to be revisited back in the queue
+	go crawler.StartRecrawler(ctx, &db)
+	//reads the sitemaps of the domains the workers found
+	go crawler.StartSitemapIngester(ctx, &db)
+
+	//worker ids name the processing lists in redis so they have to be unique across crawler instances
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "crawler"
+	}
+
+	//queue sizes are read from redis on every scrape of /metrics
+	prometheus.MustRegister(metrics.NewFrontierCollector(&db))
+
+	if cfg.Warc.Dir != "" {
+		archive, err := warc.NewWriter(cfg.Warc.Dir, hostname, cfg.Warc.MaxFileBytes)
+		if err != nil {
+			panic(err)
+		}
+		crawler.Archive = archive
+		defer func() {
+			if err := archive.Close(); err != nil {
+				fmt.Println(err)
+			}
+		}()
+	}
 
-	for i := range numWorkers {
-		go func(workerID int) {
-			defer wg.Done()
+	pool := crawler.NewPool(ctx, &db, hostname)
+	pool.Resize(cfg.Crawler.Workers)
 
-			jitter := time.Duration(rand.Intn(3000)) * time.Millisecond
-			time.Sleep(jitter)
+	//seeds, pausing, worker count and blocking domains at runtime, plus /metrics, /healthz and /readyz
+	adminServer := admin.ListenAndServe(cfg.Admin.Addr, admin.New(&db, pool, cfg.Admin.Token))
 
-			fmt.Printf("Worker %d started after %v\n", workerID, jitter)
-			crawler.Start(ctx, &db)
-		}(i)
+	<-ctx.Done()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := adminServer.Shutdown(shutdownCtx); err != nil {
+		fmt.Println(err)
 	}
 
-	wg.Wait()
+	pool.Wait()
 	fmt.Println("All workers have stopped.")
 }
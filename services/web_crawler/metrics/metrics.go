@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+	"web_crawler/database"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "crawler"
+
+var PagesFetched = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "pages_fetched_total",
+	Help:      "Pages downloaded and decoded successfully.",
+})
+
+var BytesDownloaded = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "bytes_downloaded_total",
+	Help:      "Bytes of page bodies downloaded.",
+})
+
+var FetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "fetch_duration_seconds",
+	Help:      "Time a single fetch attempt took, failed ones included.",
+	Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 15, 30},
+})
+
+var LastFetch = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "last_fetch_timestamp_seconds",
+	Help:      "Unix time the last response was received, a stalled crawl stops moving it.",
+})
+
+var Responses = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "responses_total",
+	Help:      "Responses received by http status code.",
+}, []string{"code"})
+
+// unix ms of the last response, a crawler that just started counts as having had one
+var lastResponse atomic.Int64
+
+func init() {
+	lastResponse.Store(time.Now().UnixMilli())
+}
+
+// ObserveResponse counts a response from a crawled page
+func ObserveResponse(statusCode int) {
+	now := time.Now()
+	lastResponse.Store(now.UnixMilli())
+	LastFetch.Set(float64(now.Unix()))
+	Responses.WithLabelValues(strconv.Itoa(statusCode)).Inc()
+}
+
+// LastResponse returns when the last page responded
+func LastResponse() time.Time {
+	return time.UnixMilli(lastResponse.Load())
+}
+
+var RobotsDenied = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "robots_denied_total",
+	Help:      "Urls robots.txt didn't let the crawler fetch by reason.",
+}, []string{"reason"})
+
+// kinds of duplicates counted by DedupHits
+const (
+	DedupExact = "exact"
+	DedupNear  = "near"
+	//the page's canonical url was already indexed from another url
+	DedupAlias = "alias"
+)
+
+var DedupHits = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "dedup_hits_total",
+	Help:      "Fetched pages that weren't indexed because they duplicate an indexed page.",
+}, []string{"kind"})
+
+// states a worker reports through WorkerState
+const (
+	WorkerIdle     = "idle"
+	WorkerCrawling = "crawling"
+	WorkerPaused   = "paused"
+)
+
+var workerStates = []string{WorkerIdle, WorkerCrawling, WorkerPaused}
+
+var WorkerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "worker_state",
+	Help:      "1 for the state each worker is in, 0 for the others.",
+}, []string{"worker", "state"})
+
+func SetWorkerState(workerID string, state string) {
+	for _, s := range workerStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		WorkerState.WithLabelValues(workerID, s).Set(value)
+	}
+}
+
+// RemoveWorker drops the series of a stopped worker
+func RemoveWorker(workerID string) {
+	WorkerState.DeletePartialMatch(prometheus.Labels{"worker": workerID})
+}
+
+// FrontierCollector reads the queue sizes from redis on every scrape
+type FrontierCollector struct {
+	db      *database.DataBase
+	queued  *prometheus.Desc
+	pending *prometheus.Desc
+}
+
+func NewFrontierCollector(db *database.DataBase) *FrontierCollector {
+	return &FrontierCollector{
+		db: db,
+		queued: prometheus.NewDesc(prometheus.BuildFQName(namespace, "frontier", "queued_urls"),
+			"Urls waiting in the frontier.", nil, nil),
+		pending: prometheus.NewDesc(prometheus.BuildFQName(namespace, "frontier", "pending_urls"),
+			"Queued urls plus the ones leased to a worker.", nil, nil),
+	}
+}
+
+func (c *FrontierCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.queued
+	ch <- c.pending
+}
+
+func (c *FrontierCollector) Collect(ch chan<- prometheus.Metric) {
+	queued, err := c.db.UrlQueueLength()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.queued, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.queued, prometheus.GaugeValue, float64(queued))
+	}
+
+	pending, err := c.db.PendingUrls()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.pending, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(pending))
+	}
+}
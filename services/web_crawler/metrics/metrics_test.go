@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSetWorkerState(t *testing.T) {
+	SetWorkerState("test-0", WorkerIdle)
+	SetWorkerState("test-0", WorkerCrawling)
+
+	expected := map[string]float64{WorkerIdle: 0, WorkerCrawling: 1, WorkerPaused: 0}
+	for state, value := range expected {
+		if got := testutil.ToFloat64(WorkerState.WithLabelValues("test-0", state)); got != value {
+			t.Errorf("%v: expected %v got %v", state, value, got)
+		}
+	}
+
+	RemoveWorker("test-0")
+	if n := testutil.CollectAndCount(WorkerState); n != 0 {
+		t.Errorf("expected the worker's series to be removed, %d left", n)
+	}
+}
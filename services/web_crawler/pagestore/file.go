@@ -0,0 +1,107 @@
+package pagestore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"utils"
+	"web_crawler/types"
+)
+
+const fileSuffix = ".json.gz"
+
+// FileStore keeps every page in its own file, <dir>/<first 2 hex of the hash>/<hash>.json.gz
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("file page store needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create page store directory %v %v", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(normUrl string) string {
+	hash := utils.HashUrl(normUrl)
+	return filepath.Join(s.dir, hash[:2], hash+fileSuffix)
+}
+
+func (s *FileStore) Put(page types.StoredPage) error {
+	data, err := encode(page)
+	if err != nil {
+		return err
+	}
+
+	path := s.path(page.NormUrl)
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not create page store directory %v %v", filepath.Dir(path), err)
+	}
+
+	//written next to the page and renamed so a walk never reads half a file
+	tmp, err := os.CreateTemp(filepath.Dir(path), "tmp-*")
+	if err != nil {
+		return fmt.Errorf("could not store page %v %v", page.NormUrl, err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not store page %v %v", page.NormUrl, err)
+	}
+
+	return nil
+}
+
+func (s *FileStore) Get(normUrl string) (types.StoredPage, bool, error) {
+	data, err := os.ReadFile(s.path(normUrl))
+	if errors.Is(err, os.ErrNotExist) {
+		return types.StoredPage{}, false, nil
+	}
+	if err != nil {
+		return types.StoredPage{}, false, fmt.Errorf("could not read stored page %v %v", normUrl, err)
+	}
+
+	page, err := decode(data)
+	return page, err == nil, err
+}
+
+func (s *FileStore) Delete(normUrl string) error {
+	err := os.Remove(s.path(normUrl))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not delete stored page %v %v", normUrl, err)
+	}
+	return nil
+}
+
+func (s *FileStore) Walk(fn func(types.StoredPage, error) error) error {
+	return filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(path, fileSuffix) {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fn(types.StoredPage{}, fmt.Errorf("could not read stored page %v %v", path, err))
+		}
+		page, err := decode(data)
+		if err != nil {
+			return fn(types.StoredPage{}, fmt.Errorf("%v: %v", path, err))
+		}
+		return fn(page, nil)
+	})
+}
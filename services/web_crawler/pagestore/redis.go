@@ -0,0 +1,45 @@
+package pagestore
+
+import (
+	"utils"
+	"web_crawler/database"
+	"web_crawler/types"
+)
+
+// RedisStore keeps pages next to the index, pagestore:<hash of the url>
+type RedisStore struct {
+	db *database.DataBase
+}
+
+func NewRedisStore(db *database.DataBase) *RedisStore {
+	return &RedisStore{db: db}
+}
+
+func (s *RedisStore) Put(page types.StoredPage) error {
+	data, err := encode(page)
+	if err != nil {
+		return err
+	}
+	return s.db.PutRawPage(utils.HashUrl(page.NormUrl), data)
+}
+
+func (s *RedisStore) Get(normUrl string) (types.StoredPage, bool, error) {
+	data, err := s.db.GetRawPage(utils.HashUrl(normUrl))
+	if err != nil || data == nil {
+		return types.StoredPage{}, false, err
+	}
+
+	page, err := decode(data)
+	return page, err == nil, err
+}
+
+func (s *RedisStore) Delete(normUrl string) error {
+	return s.db.DeleteRawPage(utils.HashUrl(normUrl))
+}
+
+func (s *RedisStore) Walk(fn func(types.StoredPage, error) error) error {
+	return s.db.ScanRawPages(func(data []byte) error {
+		page, err := decode(data)
+		return fn(page, err)
+	})
+}
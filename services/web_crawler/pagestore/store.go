@@ -0,0 +1,91 @@
+package pagestore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"unicode/utf8"
+	"web_crawler/database"
+	"web_crawler/types"
+)
+
+// Store keeps the raw pages that were indexed, keyed by the hash of their url
+type Store interface {
+	Put(page types.StoredPage) error
+	// Get returns false if no page is stored under normUrl
+	Get(normUrl string) (types.StoredPage, bool, error)
+	Delete(normUrl string) error
+	// Walk calls fn with every stored page until it returns an error.
+	// A page that can't be read is passed as err so the walk can go on without it.
+	Walk(fn func(page types.StoredPage, err error) error) error
+}
+
+const (
+	KindNone  = "none"
+	KindRedis = "redis"
+	KindFile  = "file"
+)
+
+// New returns the store of kind, nil for KindNone
+func New(kind string, dir string, db *database.DataBase) (Store, error) {
+	switch kind {
+	case KindNone:
+		return nil, nil
+	case KindRedis:
+		return NewRedisStore(db), nil
+	case KindFile:
+		return NewFileStore(dir)
+	}
+	return nil, fmt.Errorf("unknown page store %q", kind)
+}
+
+// json would replace the invalid utf-8 of binary documents like pdfs,
+// their content is kept base64 encoded in raw instead
+type record struct {
+	types.StoredPage
+	Raw []byte `json:"raw,omitempty"`
+}
+
+// pages are stored as gzipped json
+func encode(page types.StoredPage) ([]byte, error) {
+	rec := record{StoredPage: page}
+	if !utf8.ValidString(page.Content) {
+		rec.Raw = []byte(page.Content)
+		rec.Content = ""
+	}
+
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	if err := json.NewEncoder(gz).Encode(rec); err != nil {
+		return nil, fmt.Errorf("could not encode page %v %v", page.NormUrl, err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("could not compress page %v %v", page.NormUrl, err)
+	}
+	return b.Bytes(), nil
+}
+
+func decode(data []byte) (types.StoredPage, error) {
+	rec := record{}
+	page := types.StoredPage{}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return page, fmt.Errorf("could not decompress page %v", err)
+	}
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		return page, fmt.Errorf("could not decompress page %v", err)
+	}
+	if err = json.Unmarshal(raw, &rec); err != nil {
+		return page, fmt.Errorf("could not decode page %v", err)
+	}
+
+	page = rec.StoredPage
+	if rec.Raw != nil {
+		page.Content = string(rec.Raw)
+	}
+	return page, nil
+}
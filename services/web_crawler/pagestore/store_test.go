@@ -0,0 +1,130 @@
+package pagestore
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"web_crawler/types"
+)
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := types.StoredPage{
+		NormUrl:    "https://osu.ppy.sh/home",
+		PageUrl:    "https://osu.ppy.sh/home/",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"X-Robots-Tag": {"nofollow"}},
+		Fetched:    1700000000,
+		Content:    "<html><body>click the circles</body></html>",
+	}
+	other := types.StoredPage{NormUrl: "https://osu.ppy.sh/beatmaps", Content: "<html></html>"}
+
+	for _, p := range []types.StoredPage{page, other} {
+		if err = store.Put(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, ok, err := store.Get(page.NormUrl)
+	if err != nil || !ok {
+		t.Fatalf("expected stored page got %v %v", ok, err)
+	}
+	if !reflect.DeepEqual(got, page) {
+		t.Errorf("expected %+v got %+v", page, got)
+	}
+
+	walked := map[string]bool{}
+	if err = store.Walk(func(p types.StoredPage, err error) error {
+		if err != nil {
+			t.Error(err)
+		}
+		walked[p.NormUrl] = true
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(walked) != 2 || !walked[page.NormUrl] || !walked[other.NormUrl] {
+		t.Errorf("expected both pages to be walked got %v", walked)
+	}
+
+	//an error stops the walk
+	stop := errors.New("stop")
+	if err = store.Walk(func(types.StoredPage, error) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("expected walk to return stop got %v", err)
+	}
+
+	if err = store.Delete(page.NormUrl); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err = store.Get(page.NormUrl); ok || err != nil {
+		t.Errorf("expected deleted page to be gone %v %v", ok, err)
+	}
+	//deleting twice is fine
+	if err = store.Delete(page.NormUrl); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileStoreWalkSkipsBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := types.StoredPage{NormUrl: "https://osu.ppy.sh/home", Content: "<html></html>"}
+	if err = store.Put(page); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "broken"+fileSuffix), []byte("not gzip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	walked, failed := 0, 0
+	err = store.Walk(func(p types.StoredPage, err error) error {
+		if err != nil {
+			failed++
+			return nil
+		}
+		walked++
+		return nil
+	})
+	if err != nil || walked != 1 || failed != 1 {
+		t.Errorf("expected 1 page and 1 failure got %d %d %v", walked, failed, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if store, err := New(KindNone, "", nil); store != nil || err != nil {
+		t.Errorf("expected no store got %v %v", store, err)
+	}
+	if _, err := New(KindFile, "", nil); err == nil {
+		t.Error("expected file store without directory to fail")
+	}
+	if _, err := New("s3", "", nil); err == nil {
+		t.Error("expected unknown kind to fail")
+	}
+}
+
+func TestEncodeBinaryContent(t *testing.T) {
+	page := types.StoredPage{NormUrl: "https://osu.ppy.sh/wiki.pdf", Content: "%PDF-1.4\n\xe2\xe3\xcf\xd3\n"}
+
+	data, err := encode(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Content != page.Content {
+		t.Errorf("expected %q got %q", page.Content, got.Content)
+	}
+}
@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"mime"
+	"regexp"
+	"strings"
+	"utils"
+	"utils/analyzer"
+	"web_crawler/types"
+)
+
+// Extractor indexes documents that aren't html. It returns the same title,
+// links, images and words ParseBody returns for an html page. normUrl is the
+// url the document is indexed under.
+type Extractor interface {
+	Extract(normUrl string, content string) (title string, rawUrls []string, images []types.Image, terms *types.Terms, err error)
+}
+
+// media type -> extractor, html is parsed by ParseBody
+var extractors = map[string]Extractor{
+	"text/plain":      TextExtractor{},
+	"application/pdf": PDFExtractor{},
+}
+
+// RegisterExtractor makes documents of mediaType indexable, it has to be
+// called before crawling starts
+func RegisterExtractor(mediaType string, extractor Extractor) {
+	extractors[strings.ToLower(mediaType)] = extractor
+}
+
+// ExtractorFor returns the extractor for a Content-Type header value, false if there is none
+func ExtractorFor(contentType string) (Extractor, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+
+	extractor, ok := extractors[mediaType]
+	return extractor, ok
+}
+
+// longest first line of a text file that is used as its title
+const maxTextTitle = 200
+
+var textUrlPattern = regexp.MustCompile(`https?://[^\s<>"'()\[\]]+`)
+
+// TextExtractor indexes plain text, the first line is the title and every
+// absolute http(s) url in the text is a link
+type TextExtractor struct{}
+
+func (TextExtractor) Extract(normUrl string, content string) (string, []string, []types.Image, *types.Terms, error) {
+	title := ""
+	for line := range strings.Lines(content) {
+		if line = strings.TrimSpace(line); line != "" {
+			title = line
+			break
+		}
+	}
+	if len(title) > maxTextTitle {
+		title = strings.ToValidUTF8(title[:maxTextTitle], "")
+	}
+
+	rawUrls := make([]string, 0)
+	for _, link := range textUrlPattern.FindAllString(content, -1) {
+		//sentence punctuation after a url isn't part of it
+		rawUrls = append(rawUrls, strings.TrimRight(link, ".,;:!?"))
+	}
+
+	terms := types.NewTerms(analyzer.DetectLanguage("", content))
+	addWords(terms, utils.FieldTitle, title)
+	//blank lines separate paragraphs
+	for paragraph := range strings.SplitSeq(content, "\n\n") {
+		terms.Break()
+		addWords(terms, utils.FieldBody, paragraph)
+	}
+	addUrlWords(terms, normUrl)
+
+	return title, rawUrls, make([]types.Image, 0), terms, nil
+}
@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"utils"
+)
+
+func TestExtractorFor(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    Extractor
+	}{
+		{"text/plain; charset=utf-8", TextExtractor{}},
+		{"Application/PDF", PDFExtractor{}},
+		{"text/html", nil},
+		{"image/png", nil},
+		{"not a content type", nil},
+	}
+
+	for _, test := range tests {
+		extractor, ok := ExtractorFor(test.contentType)
+		if ok != (test.expected != nil) || extractor != test.expected {
+			t.Errorf("%v: expected %T got %T", test.contentType, test.expected, extractor)
+		}
+	}
+}
+
+func TestTextExtractor(t *testing.T) {
+	content := "\n  Circle clicking guide\n\nRead https://osu.ppy.sh/wiki/en/Gameplay, or http://example.com/faq.\nClicking circles\tis fun."
+
+	title, rawUrls, images, terms, err := TextExtractor{}.Extract("https://example.com/guide.txt", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if title != "Circle clicking guide" {
+		t.Errorf("expected first line as title got %q", title)
+	}
+	expected := []string{"https://osu.ppy.sh/wiki/en/Gameplay", "http://example.com/faq"}
+	if !reflect.DeepEqual(rawUrls, expected) {
+		t.Errorf("expected %v got %v", expected, rawUrls)
+	}
+	if len(images) != 0 {
+		t.Errorf("expected no images got %v", images)
+	}
+	if terms.Fields["circl"][utils.FieldBody] != 2 || terms.Fields["click"][utils.FieldBody] != 2 {
+		t.Errorf("expected stemmed counts got %v", terms.Fields)
+	}
+	if terms.Fields["guid"][utils.FieldTitle] != 1 || terms.Fields["guid"][utils.FieldUrl] != 1 {
+		t.Errorf("expected guide in title and url got %v", terms.Fields["guid"])
+	}
+}
+
+// builds a one page pdf with a title, a line of text and a link annotation
+func buildPDF(title string, text string, link string) string {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> /Annots [6 0 R] >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Type /Annot /Subtype /Link /Rect [0 0 100 20] /A << /S /URI /URI (%v) >> >>", link),
+		fmt.Sprintf("<< /Title (%v) >>", title),
+	}
+	stream := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%v) Tj ET", text)
+	objects[3] = fmt.Sprintf("<< /Length %d >>\nstream\n%v\nendstream", len(stream), stream)
+
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	offsets := []int{}
+	for i, object := range objects {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%v\nendobj\n", i+1, object)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info 7 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return b.String()
+}
+
+func TestPDFExtractor(t *testing.T) {
+	content := buildPDF("Mapping guide", "placing circles on the beat", "https://osu.ppy.sh/wiki/en/Beatmapping")
+
+	title, rawUrls, _, terms, err := PDFExtractor{}.Extract("https://example.com/guide.pdf", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if title != "Mapping guide" {
+		t.Errorf("expected document title got %q", title)
+	}
+	expected := []string{"https://osu.ppy.sh/wiki/en/Beatmapping"}
+	if !reflect.DeepEqual(rawUrls, expected) {
+		t.Errorf("expected %v got %v", expected, rawUrls)
+	}
+	if terms.Fields["circl"][utils.FieldBody] != 1 || terms.Fields["beat"][utils.FieldBody] != 1 {
+		t.Errorf("expected words of the page got %v", terms.Fields)
+	}
+	if terms.Fields["map"][utils.FieldTitle] != 1 {
+		t.Errorf("expected document title words got %v", terms.Fields["map"])
+	}
+}
+
+func TestPDFExtractorMalformed(t *testing.T) {
+	if _, _, _, _, err := (PDFExtractor{}).Extract("https://example.com/broken.pdf", "%PDF-1.4\nnot really"); err == nil {
+		t.Error("expected an error for a malformed pdf")
+	}
+}
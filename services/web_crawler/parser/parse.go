@@ -1,55 +1,74 @@
 package parser
 
 import (
+	"net/url"
 	"slices"
 	"strings"
+	"unicode"
 	"utils"
-	"web_crawler/consts"
+	"utils/analyzer"
 	"web_crawler/types"
 
-	"github.com/reiver/go-porterstemmer"
 	"golang.org/x/net/html"
 )
 
-// Single pass over the html
-func ParseBody(normUrl string, body *html.Node) (title string, rawUrls []string, images []types.Image, wordMap map[string]int) {
-	wordMap = make(map[string]int)
+// elements whose text isn't shown as part of the page
+var skippedElements = []string{"script", "style", "noscript"}
+
+// text in these elements is indexed under their field, everything else is body
+var elementFields = map[string]string{
+	"title": utils.FieldTitle,
+	"h1":    utils.FieldHeading,
+	"h2":    utils.FieldHeading,
+	"h3":    utils.FieldHeading,
+}
+
+// elements that start a new block of text, phrases don't span them
+var blockElements = []string{
+	"title", "h1", "h2", "h3", "h4", "h5", "h6", "p", "div", "section", "article", "header", "footer",
+	"nav", "aside", "main", "ul", "ol", "li", "dl", "dt", "dd", "table", "tr", "td", "th",
+	"blockquote", "pre", "figure", "figcaption", "form", "br", "hr",
+}
+
+// Single pass over the html, after the text was read once to detect its language
+func ParseBody(normUrl string, body *html.Node) (title string, rawUrls []string, images []types.Image, terms *types.Terms) {
+	terms = types.NewTerms(analyzer.DetectLanguage(htmlLang(body), nodeText(body)))
 	images = make([]types.Image, 0)
 	rawUrls = make([]string, 0)
 
-	var f func(*html.Node)
-	f = func(n *html.Node) {
+	var f func(*html.Node, string)
+	f = func(n *html.Node, field string) {
 		switch n.Type {
 		case html.TextNode:
-			w := strings.SplitSeq(n.Data, " ")
-			for word := range w {
-				//normalizing and stemming
-				word = strings.ToLower(word)
-				word = strings.TrimSpace(word)
-				word = utils.RemovePunctuation(word)
-
-				if len(word) < 3 {
-					continue
-				}
-
-				stem := porterstemmer.StemWithoutLowerCasing([]rune(word))
-
-				if len(stem) >= 2 &&
-					len(stem) <= 32 &&
-					!slices.Contains(consts.StopWords, word) &&
-					utils.IsAlphanumeric(string(stem)) {
-					wordMap[string(stem)]++
-				}
-			}
+			addWords(terms, field, n.Data)
 		case html.ElementNode:
+			if slices.Contains(skippedElements, n.Data) {
+				return
+			}
+			if slices.Contains(blockElements, n.Data) {
+				terms.Break()
+				defer terms.Break()
+			}
+			if elementField, ok := elementFields[n.Data]; ok {
+				field = elementField
+			}
+
 			if n.Data == "title" && n.FirstChild != nil {
 				title = n.FirstChild.Data
 			} else if n.Data == "a" {
+				href, hasHref := "", false
+				follow := true
 				for _, attr := range n.Attr {
 					if attr.Key == "href" {
-						rawUrls = append(rawUrls, attr.Val)
+						href, hasHref = attr.Val, true
+					}
+					if attr.Key == "rel" && !followRel(attr.Val) {
+						follow = false
 					}
 				}
+				if hasHref && follow {
+					rawUrls = append(rawUrls, href)
+				}
 			} else if n.Data == "img" {
 				image := types.Image{
 					PageUrl: normUrl,
@@ -71,12 +90,202 @@ func ParseBody(normUrl string, body *html.Node) (title string, rawUrls []string,
 				images = append(images, image)
 			}
 		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c, field)
+		}
+	}
+	f(body, utils.FieldBody)
+	addUrlWords(terms, normUrl)
+	return title, rawUrls, images, terms
+}
+
+// adds the terms of text under field, analyzed in the language of terms
+func addWords(terms *types.Terms, field string, text string) {
+	for _, term := range analyzer.For(terms.Lang).Analyze(text) {
+		terms.Add(term, field)
+	}
+}
+
+// adds the words in the host and path of normUrl under the url field
+func addUrlWords(terms *types.Terms, normUrl string) {
+	u, err := url.Parse(normUrl)
+	if err != nil {
+		return
+	}
+	terms.Break()
+
+	words := strings.FieldsFunc(u.Hostname()+"/"+u.Path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	addWords(terms, utils.FieldUrl, strings.Join(words, " "))
+}
+
+// links marked nofollow, ugc or sponsored are kept out of the frontier and the link graph
+func followRel(rel string) bool {
+	for value := range strings.FieldsSeq(strings.ToLower(rel)) {
+		if value == "nofollow" || value == "ugc" || value == "sponsored" {
+			return false
+		}
+	}
+	return true
+}
+
+// GetMetaRobots returns the content of every <meta name="robots"> and <meta name="botName">
+func GetMetaRobots(body *html.Node, botName string) []string {
+	values := []string{}
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "meta" {
+			name, content := "", ""
+			for _, attr := range n.Attr {
+				switch attr.Key {
+				case "name":
+					name = strings.ToLower(strings.TrimSpace(attr.Val))
+				case "content":
+					content = attr.Val
+				}
+			}
+			if name == "robots" || (botName != "" && name == botName) {
+				values = append(values, content)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(body)
+
+	return values
+}
+
+// GetCanonical returns the href of the first <link rel="canonical">, empty if there is none
+func GetCanonical(body *html.Node) string {
+	var f func(*html.Node) string
+	f = func(n *html.Node) string {
+		if n.Type == html.ElementNode && n.Data == "link" {
+			rel, href := "", ""
+			for _, attr := range n.Attr {
+				switch attr.Key {
+				case "rel":
+					rel = attr.Val
+				case "href":
+					href = strings.TrimSpace(attr.Val)
+				}
+			}
+			for _, value := range strings.Fields(strings.ToLower(rel)) {
+				if value == "canonical" && href != "" {
+					return href
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if href := f(c); href != "" {
+				return href
+			}
+		}
+		return ""
+	}
+
+	return f(body)
+}
+
+// GetAnchors returns the terms in the text of every followed link analyzed in lang,
+// href -> term -> number of links to href with the term in their text
+func GetAnchors(body *html.Node, lang string) map[string]map[string]int {
+	anchors := make(map[string]map[string]int)
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && slices.Contains(skippedElements, n.Data) {
+			return
+		}
+		if n.Type == html.ElementNode && n.Data == "a" {
+			href, hasHref := "", false
+			follow := true
+			for _, attr := range n.Attr {
+				if attr.Key == "href" {
+					href, hasHref = attr.Val, true
+				}
+				if attr.Key == "rel" && !followRel(attr.Val) {
+					follow = false
+				}
+			}
+			if hasHref && follow {
+				//a term counts once per link however often the text repeats it
+				terms := types.NewTerms(lang)
+				addWords(terms, utils.FieldAnchor, nodeText(n))
+				for term := range terms.Fields {
+					if anchors[href] == nil {
+						anchors[href] = make(map[string]int)
+					}
+					anchors[href][term]++
+				}
+			}
+			return
+		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
 			f(c)
 		}
 	}
 	f(body)
-	return title, rawUrls, images, wordMap
+
+	return anchors
+}
+
+// visible text of n, an image stands in with its alt text
+func nodeText(n *html.Node) string {
+	var text strings.Builder
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			text.WriteString(n.Data + " ")
+		case html.ElementNode:
+			if slices.Contains(skippedElements, n.Data) {
+				return
+			}
+			if n.Data == "img" {
+				for _, attr := range n.Attr {
+					if attr.Key == "alt" {
+						text.WriteString(attr.Val + " ")
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+
+	return text.String()
+}
+
+// htmlLang returns the language declared on the <html> element, empty if there is none
+func htmlLang(body *html.Node) string {
+	root := body
+	//the document holds the doctype and comments next to <html>
+	if root.Type == html.DocumentNode {
+		for root = root.FirstChild; root != nil; root = root.NextSibling {
+			if root.Type == html.ElementNode {
+				break
+			}
+		}
+	}
+	if root == nil || root.Data != "html" {
+		return ""
+	}
+
+	lang := ""
+	for _, attr := range root.Attr {
+		//lang wins over xml:lang when both are there
+		if attr.Key == "lang" || (attr.Key == "xml:lang" && lang == "") {
+			lang = attr.Val
+		}
+	}
+	return lang
 }
 
 // climb up to parent <figure>
@@ -0,0 +1,218 @@
+package parser
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"utils"
+	"utils/analyzer/analyzertest"
+
+	"golang.org/x/net/html"
+)
+
+func TestParseBodySkipsNofollowLinks(t *testing.T) {
+	page := `<html><head>
+		<meta name="robots" content="noindex">
+		<meta name="orbbot" content="nofollow">
+		<meta name="otherbot" content="noarchive">
+	</head><body>
+		<a href="/followed">followed</a>
+		<a href="/nofollow" rel="nofollow">nofollow</a>
+		<a href="/ugc" rel="external UGC">ugc</a>
+		<a href="/sponsored" rel="sponsored noopener">sponsored</a>
+		<a href="/noopener" rel="noopener">noopener</a>
+	</body></html>`
+
+	body, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, rawUrls, _, _ := ParseBody("https://example.com/", body)
+	expected := []string{"/followed", "/noopener"}
+	if !reflect.DeepEqual(rawUrls, expected) {
+		t.Errorf("expected %v got %v", expected, rawUrls)
+	}
+
+	metaRobots := GetMetaRobots(body, "orbbot")
+	if !reflect.DeepEqual(metaRobots, []string{"noindex", "nofollow"}) {
+		t.Errorf("expected robots and orbbot meta got %v", metaRobots)
+	}
+}
+
+func TestGetCanonical(t *testing.T) {
+	page := `<html><head>
+		<link rel="stylesheet" href="/style.css">
+		<link rel="Canonical" href=" https://example.com/article ">
+		<link rel="canonical" href="https://example.com/other">
+	</head><body></body></html>`
+
+	body, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if canonical := GetCanonical(body); canonical != "https://example.com/article" {
+		t.Errorf("expected first canonical got %v", canonical)
+	}
+
+	body, err = html.Parse(strings.NewReader(`<html><head></head><body></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canonical := GetCanonical(body); canonical != "" {
+		t.Errorf("expected no canonical got %v", canonical)
+	}
+}
+
+func TestParseBodyFields(t *testing.T) {
+	page := `<html><head>
+		<title>Ranking guide</title>
+		<style>.ranking { color: red }</style>
+		<script>var ranking = "script";</script>
+	</head><body>
+		<noscript>enable javascript for ranking</noscript>
+		<h1>Ranking</h1>
+		<h3>Criteria <em>ranking</em></h3>
+		<h4>Details</h4>
+		<p>Beatmaps get ranking after review.</p>
+	</body></html>`
+
+	body, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	title, _, _, terms := ParseBody("https://osu.ppy.sh/wiki/ranking-criteria", body)
+	if title != "Ranking guide" {
+		t.Errorf("expected title got %q", title)
+	}
+
+	expected := map[string]int{utils.FieldTitle: 1, utils.FieldHeading: 2, utils.FieldBody: 1, utils.FieldUrl: 1}
+	if !reflect.DeepEqual(terms.Fields["rank"], expected) {
+		t.Errorf("expected %v got %v", expected, terms.Fields["rank"])
+	}
+	if terms.Fields["detail"][utils.FieldBody] != 1 {
+		t.Errorf("expected h4 text in body got %v", terms.Fields["detail"])
+	}
+	for _, skipped := range []string{"color", "var", "script", "javascript"} {
+		if _, ok := terms.Fields[skipped]; ok {
+			t.Errorf("expected %v to be skipped", skipped)
+		}
+	}
+}
+
+func TestGetAnchors(t *testing.T) {
+	page := `<html><body>
+		<a href="/editor">osu! beatmap editor</a>
+		<a href="/editor"><img src="editor.png" alt="Editor"> <span>editor editor</span></a>
+		<a href="/sponsored" rel="sponsored">beatmap packs</a>
+		<a href="/empty"></a>
+		<a href="/script">ranked <script>var hidden = 1;</script></a>
+	</body></html>`
+
+	body, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]map[string]int{
+		"/editor": {"osu": 1, "beatmap": 1, "editor": 2},
+		"/script": {"rank": 1},
+	}
+	if anchors := GetAnchors(body, "en"); !reflect.DeepEqual(anchors, expected) {
+		t.Errorf("expected %v got %v", expected, anchors)
+	}
+}
+
+func TestParseBodyLanguage(t *testing.T) {
+	cases := []struct {
+		page      string
+		lang      string
+		term      string
+		unstemmed string
+	}{
+		//detected from the text
+		{`<html><body><p>Les joueurs cliquent sur les cercles au rythme de la musique et gagnent des points.</p></body></html>`, "fr", "joueur", "joueurs"},
+		//declared on the html element
+		{`<!DOCTYPE html><html lang="es-MX"><body><p>jugadores</p></body></html>`, "es", "jugador", "jugadores"},
+		//neither, analyzed as english
+		{`<html><body><p>players</p></body></html>`, "", "player", "players"},
+	}
+
+	for _, c := range cases {
+		body, err := html.Parse(strings.NewReader(c.page))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, _, terms := ParseBody("", body)
+		if terms.Lang != c.lang {
+			t.Errorf("expected language %q got %q", c.lang, terms.Lang)
+		}
+		if _, ok := terms.Fields[c.term]; !ok {
+			t.Errorf("expected %v in %v", c.term, terms.Fields)
+		}
+		if _, ok := terms.Fields[c.unstemmed]; ok {
+			t.Errorf("expected %v to be stemmed", c.unstemmed)
+		}
+	}
+}
+
+func TestParseBodyPositions(t *testing.T) {
+	page := `<html><body><p>The osu game <em>client</em></p><p>game modes</p></body></html>`
+
+	body, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, _, terms := ParseBody("https://example.com/", body)
+
+	osu, game, client := terms.Positions["osu"], terms.Positions["game"], terms.Positions["client"]
+	if len(osu) != 1 || len(game) != 2 || len(client) != 1 {
+		t.Fatalf("unexpected positions %v", terms.Positions)
+	}
+	//stop words are left out, inline elements don't break a phrase
+	if game[0] != osu[0]+1 || client[0] != game[0]+1 {
+		t.Errorf("expected osu game client to be adjacent got %v %v %v", osu, game, client)
+	}
+	//a new paragraph does
+	if terms.Positions["mode"][0] != game[1]+1 || game[1] <= client[0]+1 {
+		t.Errorf("expected a gap between paragraphs got %v", terms.Positions)
+	}
+}
+
+// index time analysis has to give the terms a query for the same text looks up
+func TestParseBodyGolden(t *testing.T) {
+	cases, err := analyzertest.ReadGolden(analyzertest.GoldenFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		body, err := html.Parse(strings.NewReader(`<html lang="en"><p>` + html.EscapeString(c.Input) + "</p></html>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, _, terms := ParseBody("", body)
+
+		//terms in the order they are in the text
+		ordered := []string{}
+		for term, positions := range terms.Positions {
+			for _, position := range positions {
+				for len(ordered) <= position {
+					ordered = append(ordered, "")
+				}
+				ordered[position] = term
+			}
+		}
+		ordered = slices.DeleteFunc(ordered, func(term string) bool { return term == "" })
+
+		if !reflect.DeepEqual(ordered, c.Terms) {
+			t.Errorf("%q: expected %v got %v", c.Input, c.Terms, ordered)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"utils"
+	"utils/analyzer"
+	"web_crawler/types"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// PDFExtractor indexes the text of a pdf, the title comes from the document
+// info and link annotations are the links
+type PDFExtractor struct{}
+
+func (PDFExtractor) Extract(normUrl string, content string) (title string, rawUrls []string, images []types.Image, terms *types.Terms, err error) {
+	//the pdf reader panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed pdf %v: %v", normUrl, r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", nil, nil, nil, fmt.Errorf("could not open pdf %v %v", normUrl, err)
+	}
+
+	text, err := reader.GetPlainText()
+	if err != nil {
+		return "", nil, nil, nil, fmt.Errorf("could not extract text of pdf %v %v", normUrl, err)
+	}
+	plainText, err := io.ReadAll(text)
+	if err != nil {
+		return "", nil, nil, nil, fmt.Errorf("could not extract text of pdf %v %v", normUrl, err)
+	}
+
+	//the catalog can declare the language of the document
+	lang := reader.Trailer().Key("Root").Key("Lang").Text()
+	terms = types.NewTerms(analyzer.DetectLanguage(lang, string(plainText)))
+	addWords(terms, utils.FieldBody, string(plainText))
+	addUrlWords(terms, normUrl)
+
+	rawUrls = make([]string, 0)
+	for i := 1; i <= reader.NumPage(); i++ {
+		annots := reader.Page(i).V.Key("Annots")
+		for j := range annots.Len() {
+			annot := annots.Index(j)
+			if annot.Key("Subtype").Name() != "Link" {
+				continue
+			}
+			if uri := annot.Key("A").Key("URI").RawString(); uri != "" {
+				rawUrls = append(rawUrls, uri)
+			}
+		}
+	}
+
+	title = strings.TrimSpace(reader.Trailer().Key("Info").Key("Title").Text())
+	terms.Break()
+	addWords(terms, utils.FieldTitle, title)
+
+	return title, rawUrls, make([]types.Image, 0), terms, nil
+}
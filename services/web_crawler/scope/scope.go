@@ -0,0 +1,114 @@
+package scope
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"web_crawler/types"
+)
+
+type Reason string
+
+const (
+	ReasonInScope    Reason = ""
+	ReasonNotAllowed Reason = "host-not-allowed"
+	ReasonDenied     Reason = "host-denied"
+	ReasonExcluded   Reason = "excluded"
+	ReasonTooDeep    Reason = "too-deep"
+	ReasonBudget     Reason = "domain-budget"
+	ReasonInvalid    Reason = "invalid-url"
+	//blocked through the admin api
+	ReasonBlocked Reason = "domain-blocked"
+	//spider traps, see traps.go
+	ReasonTrapDepth     Reason = "trap-path-depth"
+	ReasonTrapRepeats   Reason = "trap-repeated-segments"
+	ReasonTrapThrottled Reason = "trap-throttled"
+	ReasonTrapBlocked   Reason = "trap-blocked"
+)
+
+// Policy decides which urls the frontier accepts
+type Policy struct {
+	types.Scope
+	exclude []*regexp.Regexp
+}
+
+func New(s types.Scope) (*Policy, error) {
+	p := &Policy{Scope: s}
+
+	for _, pattern := range s.Exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %v %v", pattern, err)
+		}
+		p.exclude = append(p.exclude, re)
+	}
+
+	hosts := append(slices.Clone(s.Allow), s.Deny...)
+	limits := []types.TrapLimits{s.Traps.TrapLimits}
+	for _, override := range s.Traps.Hosts {
+		hosts = append(hosts, override.Host)
+		limits = append(limits, override.TrapLimits)
+	}
+	for _, host := range hosts {
+		if strings.TrimPrefix(host, "*.") == "" {
+			return nil, fmt.Errorf("invalid host pattern %q", host)
+		}
+	}
+
+	if s.MaxDepth < 0 || s.MaxPagesPerDomain < 0 {
+		return nil, fmt.Errorf("max_depth and max_pages_per_domain can't be negative")
+	}
+
+	for _, l := range limits {
+		if min(l.TemplateThrottleUrls, l.TemplateBlockUrls, l.ParamThrottleCombinations, l.ParamBlockCombinations, l.TemplateBlockDuplicates) < -1 {
+			return nil, fmt.Errorf("trap limits can't be below -1")
+		}
+	}
+
+	return p, nil
+}
+
+// Check returns why a url found depth links away from a seed is out of scope,
+// ReasonInScope if it isn't. The per domain budget is kept by the frontier.
+func (p *Policy) Check(normUrl string, depth int) Reason {
+	u, err := url.Parse(normUrl)
+	if err != nil || u.Hostname() == "" {
+		return ReasonInvalid
+	}
+	host := strings.ToLower(u.Hostname())
+
+	if matchesAny(host, p.Deny) {
+		return ReasonDenied
+	}
+	if len(p.Allow) > 0 && !matchesAny(host, p.Allow) {
+		return ReasonNotAllowed
+	}
+
+	for _, re := range p.exclude {
+		if re.MatchString(normUrl) {
+			return ReasonExcluded
+		}
+	}
+
+	if p.MaxDepth > 0 && depth > p.MaxDepth {
+		return ReasonTooDeep
+	}
+
+	return ReasonInScope
+}
+
+func matchesAny(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if host == suffix || strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package scope
+
+import (
+	"testing"
+	"web_crawler/types"
+)
+
+func TestCheck(t *testing.T) {
+	p, err := New(types.Scope{
+		Allow:    []string{"*.wikipedia.org", "osu.ppy.sh"},
+		Deny:     []string{"*.de.wikipedia.org"},
+		Exclude:  []string{`/wiki/Special:`, `\?action=edit`},
+		MaxDepth: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Reason{
+		"https://en.wikipedia.org/wiki/Osu!":               ReasonInScope,
+		"https://wikipedia.org/":                           ReasonInScope,
+		"https://osu.ppy.sh/home":                          ReasonInScope,
+		"https://ppy.sh/":                                  ReasonNotAllowed,
+		"https://notwikipedia.org/":                        ReasonNotAllowed,
+		"https://de.wikipedia.org/wiki/Osu!":               ReasonDenied,
+		"https://en.wikipedia.org/wiki/Special:Random":     ReasonExcluded,
+		"https://en.wikipedia.org/w/index.php?action=edit": ReasonExcluded,
+	}
+	for normUrl, reason := range expected {
+		if got := p.Check(normUrl, 1); got != reason {
+			t.Errorf("%v: expected %q got %q", normUrl, reason, got)
+		}
+	}
+
+	if got := p.Check("https://en.wikipedia.org/wiki/Osu!", 3); got != ReasonTooDeep {
+		t.Errorf("expected %q got %q", ReasonTooDeep, got)
+	}
+}
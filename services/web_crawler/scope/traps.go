@@ -0,0 +1,163 @@
+package scope
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+	"web_crawler/types"
+)
+
+// DefaultTrapLimits are used for every limit the scope leaves at 0
+var DefaultTrapLimits = types.TrapLimits{
+	TemplateThrottleUrls:      1000,
+	TemplateBlockUrls:         5000,
+	ParamThrottleCombinations: 32,
+	ParamBlockCombinations:    128,
+	TemplateBlockDuplicates:   20,
+}
+
+// TrapLimits returns the limits for the templates of normUrl's host, a nil policy has the defaults
+func (p *Policy) TrapLimits(normUrl string) types.TrapLimits {
+	limits := DefaultTrapLimits
+	if p == nil {
+		return limits
+	}
+
+	limits = withDefaults(p.Traps.TrapLimits, limits)
+	u, err := url.Parse(normUrl)
+	if err != nil {
+		return limits
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, override := range p.Traps.Hosts {
+		if matchesAny(host, []string{override.Host}) {
+			return withDefaults(override.TrapLimits, limits)
+		}
+	}
+	return limits
+}
+
+// fills the limits left at 0 from defaults
+func withDefaults(limits types.TrapLimits, defaults types.TrapLimits) types.TrapLimits {
+	for _, limit := range []struct {
+		value    *int64
+		fallback int64
+	}{
+		{&limits.TemplateThrottleUrls, defaults.TemplateThrottleUrls},
+		{&limits.TemplateBlockUrls, defaults.TemplateBlockUrls},
+		{&limits.ParamThrottleCombinations, defaults.ParamThrottleCombinations},
+		{&limits.ParamBlockCombinations, defaults.ParamBlockCombinations},
+		{&limits.TemplateBlockDuplicates, defaults.TemplateBlockDuplicates},
+	} {
+		if *limit.value == 0 {
+			*limit.value = limit.fallback
+		}
+	}
+	return limits
+}
+
+// urls with more path segments than this are taken for a spider trap
+const maxPathSegments = 16
+
+// a path segment that shows up this many times means the links loop, e.g. /a/b/a/b/a/b
+const maxSegmentRepeats = 3
+
+// segments at least this long with this share of digits are ids or session tokens
+const minIdLength = 16
+const minIdDigitShare = 0.25
+
+// Template strips what varies between urls of the same kind: digits become
+// {n}, ids and session tokens {id} and query values are dropped, keeping only
+// the sorted parameter names. It returns the template of the whole url and of
+// its host and path alone.
+func Template(normUrl string) (string, string) {
+	u, err := url.Parse(normUrl)
+	if err != nil {
+		return normUrl, normUrl
+	}
+
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, segment := range segments {
+		//path parameters such as ;jsessionid=...
+		segment, _, _ = strings.Cut(segment, ";")
+		if looksLikeId(segment) {
+			segments[i] = "{id}"
+		} else {
+			segments[i] = replaceDigits(segment)
+		}
+	}
+	pathTemplate := u.Host + strings.Join(segments, "/")
+
+	names := []string{}
+	for param := range strings.SplitSeq(u.RawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return pathTemplate, pathTemplate
+	}
+	slices.Sort(names)
+
+	return pathTemplate + "?" + strings.Join(names, "&"), pathTemplate
+}
+
+// CheckPath catches spider traps that show in a single url: paths that are too deep or loop
+func CheckPath(normUrl string) Reason {
+	u, err := url.Parse(normUrl)
+	if err != nil {
+		return ReasonInvalid
+	}
+
+	segments := strings.FieldsFunc(u.EscapedPath(), func(r rune) bool { return r == '/' })
+	if len(segments) > maxPathSegments {
+		return ReasonTrapDepth
+	}
+
+	seen := map[string]int{}
+	for _, segment := range segments {
+		seen[segment]++
+		if seen[segment] >= maxSegmentRepeats {
+			return ReasonTrapRepeats
+		}
+	}
+
+	return ReasonInScope
+}
+
+func looksLikeId(segment string) bool {
+	if len(segment) < minIdLength {
+		return false
+	}
+
+	digits := 0
+	for _, r := range segment {
+		switch {
+		case unicode.IsDigit(r):
+			digits++
+		case r == '-' || r == '_' || r < unicode.MaxASCII && unicode.IsLetter(r):
+		default:
+			return false
+		}
+	}
+	return float64(digits) >= float64(len(segment))*minIdDigitShare
+}
+
+func replaceDigits(segment string) string {
+	var b strings.Builder
+	inDigits := false
+	for _, r := range segment {
+		if r >= '0' && r <= '9' {
+			if !inDigits {
+				b.WriteString("{n}")
+			}
+			inDigits = true
+			continue
+		}
+		inDigits = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package scope
+
+import (
+	"testing"
+	"web_crawler/types"
+)
+
+func TestTemplate(t *testing.T) {
+	expected := map[string][2]string{
+		"https://example.com/calendar/2024/05/17":             {"example.com/calendar/{n}/{n}/{n}", "example.com/calendar/{n}/{n}/{n}"},
+		"https://example.com/shop?size=m&color=red&size=l":    {"example.com/shop?color&size", "example.com/shop"},
+		"https://example.com/s/3f2b9c1e8d7a6b5c4d3e2f1a/cart": {"example.com/s/{id}/cart", "example.com/s/{id}/cart"},
+		"https://example.com/page;jsessionid=A1B2C3":          {"example.com/page", "example.com/page"},
+		"https://example.com/how-to-play-osu-2024":            {"example.com/how-to-play-osu-{n}", "example.com/how-to-play-osu-{n}"},
+	}
+
+	for normUrl, templates := range expected {
+		template, pathTemplate := Template(normUrl)
+		if template != templates[0] || pathTemplate != templates[1] {
+			t.Errorf("%v: expected %v got %v %v", normUrl, templates, template, pathTemplate)
+		}
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	expected := map[string]Reason{
+		"https://example.com/a/b/c":                                     ReasonInScope,
+		"https://example.com/a/b/a/b/a/b":                               ReasonTrapRepeats,
+		"https://example.com/1/2/3/4/5/6/7/8/9/10/11/12/13/14/15/16/17": ReasonTrapDepth,
+	}
+
+	for normUrl, reason := range expected {
+		if got := CheckPath(normUrl); got != reason {
+			t.Errorf("%v: expected %q got %q", normUrl, reason, got)
+		}
+	}
+}
+
+func TestTrapLimits(t *testing.T) {
+	p, err := New(types.Scope{
+		Traps: types.Traps{
+			TrapLimits: types.TrapLimits{TemplateBlockUrls: 8000},
+			Hosts: []types.HostTrapLimits{
+				{Host: "news.example", TrapLimits: types.TrapLimits{TemplateThrottleUrls: -1, TemplateBlockUrls: -1}},
+				{Host: "*.example", TrapLimits: types.TrapLimits{TemplateBlockDuplicates: 50}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//limits left at 0 fall back to the scope and then the defaults
+	expected := map[string]types.TrapLimits{
+		"https://other.org/a":        {TemplateThrottleUrls: 1000, TemplateBlockUrls: 8000, ParamThrottleCombinations: 32, ParamBlockCombinations: 128, TemplateBlockDuplicates: 20},
+		"https://news.example/a/1":   {TemplateThrottleUrls: -1, TemplateBlockUrls: -1, ParamThrottleCombinations: 32, ParamBlockCombinations: 128, TemplateBlockDuplicates: 20},
+		"https://shop.example:8080/": {TemplateThrottleUrls: 1000, TemplateBlockUrls: 8000, ParamThrottleCombinations: 32, ParamBlockCombinations: 128, TemplateBlockDuplicates: 50},
+	}
+	for normUrl, limits := range expected {
+		if got := p.TrapLimits(normUrl); got != limits {
+			t.Errorf("%v: expected %+v got %+v", normUrl, limits, got)
+		}
+	}
+
+	var nilPolicy *Policy
+	if got := nilPolicy.TrapLimits("https://other.org/"); got != DefaultTrapLimits {
+		t.Errorf("expected the defaults without a policy got %+v", got)
+	}
+
+	_, err = New(types.Scope{Traps: types.Traps{TrapLimits: types.TrapLimits{TemplateBlockUrls: -2}}})
+	if err == nil {
+		t.Error("expected limits below -1 to be rejected")
+	}
+}
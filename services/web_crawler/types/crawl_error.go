@@ -0,0 +1,9 @@
+package types
+
+// a url that could not be crawled, kept for the admin api
+type CrawlError struct {
+	Url   string `json:"url"`
+	Error string `json:"error"`
+	//unix seconds
+	Time int64 `json:"time"`
+}
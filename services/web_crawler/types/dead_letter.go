@@ -0,0 +1,8 @@
+package types
+
+// a url that ran out of retries, kept for the admin api
+type DeadLetter struct {
+	Url string `json:"url"`
+	//the last error it failed with
+	Reason string `json:"reason"`
+}
@@ -4,4 +4,6 @@ type Document struct {
 	NormUrl string
 	Length  int
 	Title   string
+	//ISO 639-1 code, empty if the language is unknown
+	Lang string
 }
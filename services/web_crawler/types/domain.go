@@ -7,4 +7,9 @@ type Domain struct {
 	LastCrawled int64
 	Allowed     []string
 	Disallowed  []string
+	Sitemaps    []string
+	//unix seconds after which robots.txt has to be fetched again
+	RobotsExpires int64
+	//robots.txt couldn't be fetched, nothing may be crawled until it expires
+	RobotsUnavailable bool
 }
@@ -0,0 +1,20 @@
+package types
+
+// what the crawler remembers about the last fetch of a url
+// times are unix seconds
+type FetchState struct {
+	NormUrl      string
+	LastFetched  int64
+	LastChanged  int64
+	ETag         string
+	LastModified string
+	ContentHash  string
+	//url the page was indexed under on the last change
+	Canonical string
+	//estimated chance that the page changed between two fetches
+	ChangeRate float64
+	//seconds between fetches
+	Interval int64
+	//final http status of the last fetch, failed ones included
+	StatusCode int
+}
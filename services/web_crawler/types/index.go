@@ -1,9 +1,69 @@
 package types
 
+import "slices"
+
 //term -> posting
 type InvertedIndex map[string]Posting
 
 type Posting struct {
 	NormUrl       string
 	TermFrequency int
+	//field -> frequency, they add up to TermFrequency
+	Fields map[string]int
+	//where the term is in the document, ascending
+	Positions []int
+}
+
+//term -> field -> frequency
+type TermFields map[string]map[string]int
+
+func (t TermFields) Add(term string, field string) {
+	if t[term] == nil {
+		t[term] = make(map[string]int)
+	}
+	t[term][field]++
+}
+
+// Frequencies returns term -> frequency counted over fields, over every field if none are given
+func (t TermFields) Frequencies(fields ...string) map[string]int {
+	frequencies := make(map[string]int)
+	for term, byField := range t {
+		for field, frequency := range byField {
+			if len(fields) == 0 || slices.Contains(fields, field) {
+				frequencies[term] += frequency
+			}
+		}
+	}
+	return frequencies
+}
+
+// positions skipped between blocks of text so phrases don't match across them
+// and terms in different blocks are further apart than terms in one sentence
+const blockGap = 10
+
+// the terms of a document with where they were found
+type Terms struct {
+	Fields TermFields
+	//term -> positions in the document, ascending
+	Positions map[string][]int
+	//ISO 639-1 code of the language the terms were analyzed in, empty if unknown
+	Lang string
+	//position of the next term
+	next int
+}
+
+func NewTerms(lang string) *Terms {
+	return &Terms{Fields: make(TermFields), Positions: make(map[string][]int), Lang: lang}
+}
+
+// Add counts term in field at the next position
+func (t *Terms) Add(term string, field string) {
+	t.Fields.Add(term, field)
+	t.Positions[term] = append(t.Positions[term], t.next)
+	t.next++
+}
+
+// Break ends a block of text, the next term is not next to the last one
+func (t *Terms) Break() {
+	t.next += blockGap
 }
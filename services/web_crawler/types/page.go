@@ -4,4 +4,6 @@ type Page struct {
 	NormUrl string
 	Content string
 	OutLinks []string
+	//set by noarchive, the content may be hashed but not stored
+	NoArchive bool
 }
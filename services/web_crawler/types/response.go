@@ -0,0 +1,15 @@
+package types
+
+import "net/http"
+
+// what the crawler got back when fetching a url
+type Response struct {
+	Url string
+	//url the content was served from after following redirects
+	FinalUrl string
+	//every url redirected to, in order, the last one is FinalUrl
+	Redirects  []string
+	StatusCode int
+	Header     http.Header
+	Content    string
+}
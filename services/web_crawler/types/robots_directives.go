@@ -0,0 +1,11 @@
+package types
+
+// page level robots rules from <meta name="robots"> and X-Robots-Tag
+type RobotsDirectives struct {
+	//the page must not get postings in the index
+	NoIndex bool
+	//links on the page must not be followed or counted in the link graph
+	NoFollow bool
+	//the page's content must not be kept
+	NoArchive bool
+}
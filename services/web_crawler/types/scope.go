@@ -0,0 +1,45 @@
+package types
+
+// what the crawler may crawl, the scope section of the config
+type Scope struct {
+	//urls the crawl starts from, at depth 0
+	Seeds []string `yaml:"seeds"`
+	//hosts that may be crawled, empty allows every host.
+	//"*.example.com" matches example.com and all of its subdomains
+	Allow []string `yaml:"allow"`
+	//hosts that are never crawled, wins over Allow
+	Deny []string `yaml:"deny"`
+	//regular expressions, matching urls are never crawled
+	Exclude []string `yaml:"exclude"`
+	//links followed from a seed, 0 is unlimited
+	MaxDepth int `yaml:"max_depth"`
+	//urls queued per host, 0 is unlimited
+	MaxPagesPerDomain int64 `yaml:"max_pages_per_domain"`
+	//when a url template is taken for a spider trap
+	Traps Traps `yaml:"traps"`
+}
+
+// limits on the urls of one template, like example.com/article/{n}, before it
+// is taken for a spider trap. 0 keeps the default, -1 turns a limit off
+type TrapLimits struct {
+	//distinct urls of one template before it is throttled and blocked
+	TemplateThrottleUrls int64 `yaml:"template_throttle_urls"`
+	TemplateBlockUrls    int64 `yaml:"template_block_urls"`
+	//distinct combinations of query parameters on one path before it is throttled and blocked
+	ParamThrottleCombinations int64 `yaml:"param_throttle_combinations"`
+	ParamBlockCombinations    int64 `yaml:"param_block_combinations"`
+	//pages of one template that turned out to be duplicates before it is blocked
+	TemplateBlockDuplicates int64 `yaml:"template_block_duplicates"`
+}
+
+type Traps struct {
+	TrapLimits `yaml:",inline"`
+	//the first one matching a host is used, limits it leaves at 0 are taken from above
+	Hosts []HostTrapLimits `yaml:"hosts"`
+}
+
+type HostTrapLimits struct {
+	//"*.example.com" matches example.com and all of its subdomains
+	Host       string `yaml:"host"`
+	TrapLimits `yaml:",inline"`
+}
@@ -0,0 +1,10 @@
+package types
+
+// a <url> from a sitemap
+type SitemapEntry struct {
+	Loc string
+	//unix seconds, 0 if the sitemap didn't say
+	LastMod int64
+	//0.0 to 1.0, the protocol default is 0.5
+	Priority float64
+}
@@ -0,0 +1,13 @@
+package types
+
+// a url template the crawler found to generate endless urls
+type SpiderTrap struct {
+	//see scope.Template
+	Template string
+	//"throttled" or "blocked"
+	State string
+	//which heuristic caught it
+	Reason string
+	//unix seconds
+	Detected int64
+}
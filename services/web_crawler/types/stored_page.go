@@ -0,0 +1,16 @@
+package types
+
+import "net/http"
+
+// a fetched page as it was indexed, kept so the index can be rebuilt without crawling
+type StoredPage struct {
+	//canonical url the page is indexed under
+	NormUrl string `json:"url"`
+	//url the content was served from, relative links resolve against it
+	PageUrl    string      `json:"page_url"`
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header"`
+	//unix seconds
+	Fetched int64  `json:"fetched"`
+	Content string `json:"content"`
+}
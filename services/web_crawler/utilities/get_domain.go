@@ -7,22 +7,15 @@ import (
 )
 
 func GetDomainFromUrl(rawUrl string) (string, error) {
-	u, err := url.Parse(rawUrl)
-
+	normUrl, err := CanonicalizeUrl(rawUrl)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse URL: %w", err)
 	}
 
-	if u.Scheme == "http" {
-		u.Host = strings.Replace(u.Host, ":80", "", 1)
-	}
-
-	if u.Scheme == "https" {
-		u.Host = strings.Replace(u.Host, ":443", "", 1)
+	u, err := url.Parse(normUrl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse URL: %w", err)
 	}
 
-	u.Host = strings.ToLower(u.Host)
-	u.Host = strings.TrimPrefix(u.Host, "www.")
-
-	return u.Host, nil
+	return strings.TrimPrefix(u.Host, "www."), nil
 }
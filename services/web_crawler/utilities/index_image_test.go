@@ -0,0 +1,26 @@
+package utilities
+
+import (
+	"reflect"
+	"testing"
+	"utils/analyzer/analyzertest"
+	"web_crawler/types"
+)
+
+func TestIndexImageGolden(t *testing.T) {
+	cases, err := analyzertest.ReadGolden(analyzertest.GoldenFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		expected := map[string]int{}
+		for _, term := range c.Terms {
+			expected[term]++
+		}
+
+		if got := IndexImage(types.Image{Text: c.Input}); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: expected %v got %v", c.Input, expected, got)
+		}
+	}
+}
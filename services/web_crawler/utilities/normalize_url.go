@@ -2,42 +2,243 @@ package utilities
 
 import (
 	"fmt"
+	"net"
 	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
 )
 
+// query parameters that only track where a visitor came from, they are
+// dropped from every url. a trailing * matches any parameter with that prefix.
+var TrackingParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"dclid",
+	"msclkid",
+	"yclid",
+	"igshid",
+	"mc_cid",
+	"mc_eid",
+	"_ga",
+	"_hsenc",
+	"_hsmi",
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// NormalizeLink resolves link against base and canonicalizes the result.
+// An empty string means the link can't be crawled, e.g. mailto: or javascript:
 func NormalizeLink(base string, link string) (string, error) {
 	baseURL, err := url.Parse(base)
 	if err != nil {
 		return "", err
 	}
 
-	ref, err := url.Parse(link)
+	ref, err := url.Parse(strings.TrimSpace(link))
 	if err != nil {
 		return "", err
 	}
 
-	absURL := baseURL.ResolveReference(ref)
+	return canonicalize(baseURL.ResolveReference(ref))
+}
 
-	if absURL.Scheme == "" || absURL.Host == "" {
+// CanonicalizeUrl turns an absolute url into the form it is stored under.
+// An empty string means the url can't be crawled.
+func CanonicalizeUrl(rawUrl string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return "", err
+	}
+
+	return canonicalize(u)
+}
+
+func canonicalize(u *url.URL) (string, error) {
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, ok := defaultPorts[u.Scheme]; !ok || u.Opaque != "" {
+		return "", nil
+	}
+
+	host, err := canonicalHost(u.Hostname())
+	if err != nil {
+		return "", fmt.Errorf("invalid host in url: %v %v", u.Redacted(), err)
+	}
+	if host == "" {
 		return "", nil
 	}
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	path := removeDotSegments(normalizeEscapes(u.EscapedPath()))
+	if path == "" {
+		path = "/"
+	}
+
+	//user info and the fragment never make a different page
+	canonical := u.Scheme + "://" + host + path
+	if query := canonicalQuery(u.RawQuery); query != "" {
+		canonical += "?" + query
+	}
+
+	return canonical, nil
+}
+
+// lookup mapping without the strict checks, real links use hosts like
+// foo_bar.example.com and -x.com that browsers resolve just fine
+var hostProfile = idna.New(idna.MapForLookup(), idna.StrictDomainName(false), idna.CheckHyphens(false))
+
+// lower case, punycode for internationalized names and no trailing dot
+func canonicalHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || net.ParseIP(host) != nil {
+		return host, nil
+	}
+
+	return hostProfile.ToASCII(host)
+}
+
+// drops tracking and empty parameters and sorts the rest so the order links use doesn't matter
+func canonicalQuery(rawQuery string) string {
+	params := []string{}
+	for param := range strings.SplitSeq(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		param = normalizeEscapes(param)
+
+		key, _, _ := strings.Cut(param, "=")
+		if isTrackingParam(key) {
+			continue
+		}
+		params = append(params, param)
+	}
+
+	//stable so repeated keys keep their order
+	sort.SliceStable(params, func(i, j int) bool {
+		keyI, _, _ := strings.Cut(params[i], "=")
+		keyJ, _, _ := strings.Cut(params[j], "=")
+		return keyI < keyJ
+	})
 
-	return absURL.String(), nil
+	return strings.Join(params, "&")
+}
+
+func isTrackingParam(key string) bool {
+	key, err := url.QueryUnescape(key)
+	if err != nil {
+		return false
+	}
+	key = strings.ToLower(key)
+
+	for _, param := range TrackingParams {
+		if prefix, ok := strings.CutSuffix(param, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == param {
+			return true
+		}
+	}
+	return false
+}
+
+// decodes escaped unreserved characters and upper cases the hex digits of
+// every other escape, "%7e" and "~" or "%2f" and "%2F" are the same url
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(strings.ToUpper(s[i : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
 }
 
-func NormalizeUrlSlice(base string, rawUrls []string) ([]string, error) {
+// RFC 3986 section 5.2.4
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	out := []string{}
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			//never climb above the root, out[0] is the empty segment before the leading slash
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, segment)
+		}
+	}
+
+	return strings.Join(out, "/")
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// NormalizeUrlSlice normalizes the links of base, links that can't be
+// normalized are left out so one bad href doesn't lose the whole page
+func NormalizeUrlSlice(base string, rawUrls []string) []string {
 	normalizedUrls := make([]string, 0)
 
 	for _, rawUrl := range rawUrls {
 		normUrl, err := NormalizeLink(base, rawUrl)
-		if err != nil {
-			return normalizedUrls, fmt.Errorf("could not normalize url: %v %v", rawUrl, err)
-		}
-
-		if normUrl != "" {
-			normalizedUrls = append(normalizedUrls, normUrl)
+		if err != nil || normUrl == "" {
+			continue
 		}
+		normalizedUrls = append(normalizedUrls, normUrl)
 	}
 
-	return normalizedUrls, nil
+	return normalizedUrls
 }
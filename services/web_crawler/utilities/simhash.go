@@ -0,0 +1,40 @@
+package utilities
+
+import (
+	"hash/fnv"
+	"math/bits"
+)
+
+// SimHash fingerprints a page from its terms weighted by their score.
+// pages that share most of their text end up a few bits apart.
+func SimHash(wordMap map[string]int) uint64 {
+	var weights [64]int
+
+	for term, score := range wordMap {
+		h := fnv.New64a()
+		h.Write([]byte(term))
+		termHash := h.Sum64()
+
+		for bit := range 64 {
+			if termHash&(1<<bit) != 0 {
+				weights[bit] += score
+			} else {
+				weights[bit] -= score
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+
+	return fingerprint
+}
+
+// HammingDistance returns the number of bits two fingerprints differ in
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,37 @@
+package utilities
+
+import (
+	"fmt"
+	"testing"
+)
+
+func words(prefix string, n int) map[string]int {
+	wordMap := map[string]int{}
+	for i := range n {
+		wordMap[fmt.Sprintf("%v%d", prefix, i)] = 1 + i%3
+	}
+	return wordMap
+}
+
+func TestSimHashNearDuplicates(t *testing.T) {
+	page := words("word", 300)
+
+	//a changed timestamp and ad slot
+	changed := words("word", 300)
+	changed["timestamp"] = 1
+	changed["advert"] = 1
+	delete(changed, "word7")
+
+	if d := HammingDistance(SimHash(page), SimHash(changed)); d > 3 {
+		t.Errorf("expected near-duplicates to be at most 3 bits apart got %d", d)
+	}
+
+	other := words("other", 300)
+	if d := HammingDistance(SimHash(page), SimHash(other)); d <= 3 {
+		t.Errorf("expected different pages to be far apart got %d", d)
+	}
+
+	if SimHash(page) != SimHash(words("word", 300)) {
+		t.Error("expected the same terms to give the same fingerprint")
+	}
+}
@@ -0,0 +1,113 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Record is a warc record, header names are canonicalized by textproto
+type Record struct {
+	Header textproto.MIMEHeader
+	Block  []byte
+}
+
+func (r Record) Type() string {
+	return r.Header.Get("WARC-Type")
+}
+
+func (r Record) TargetURI() string {
+	return r.Header.Get("WARC-Target-URI")
+}
+
+// Response parses the block of a response record
+func (r Record) Response() (*http.Response, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(r.Block)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse response of %v %v", r.TargetURI(), err)
+	}
+	return resp, nil
+}
+
+// Reader reads the records of a warc file, compressed or not
+type Reader struct {
+	r *textproto.Reader
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		//gzip reads the members one after the other
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(gz)
+	}
+
+	return &Reader{r: textproto.NewReader(br)}, nil
+}
+
+// Next returns the next record or io.EOF after the last one
+func (r *Reader) Next() (Record, error) {
+	line, err := r.r.ReadLine()
+	//records end with two blank lines that are skipped here
+	for err == nil && line == "" {
+		line, err = r.r.ReadLine()
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	if !strings.HasPrefix(line, "WARC/") {
+		return Record{}, fmt.Errorf("expected a warc record got %q", line)
+	}
+
+	header, err := r.r.ReadMIMEHeader()
+	if err != nil {
+		return Record{}, fmt.Errorf("could not read warc header %v", err)
+	}
+
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return Record{}, fmt.Errorf("invalid warc content-length %q", header.Get("Content-Length"))
+	}
+
+	block := make([]byte, length)
+	if _, err = io.ReadFull(r.r.R, block); err != nil {
+		return Record{}, fmt.Errorf("could not read warc block %v", err)
+	}
+
+	return Record{Header: header, Block: block}, nil
+}
+
+// Files returns the finished warc files in dir sorted by name, which orders
+// the files of every crawler instance by when they were started
+func Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read warc directory %v %v", dir, err)
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, ".warc.gz") || strings.HasSuffix(name, ".warc")) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
@@ -0,0 +1,174 @@
+package warc
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testResponse(t *testing.T, rawUrl string, body string) *http.Response {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{Method: http.MethodGet, URL: u, Header: http.Header{"User-Agent": {"TestBot/1.0"}}}
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		Request:    req,
+	}
+}
+
+func readAll(t *testing.T, path string) []Record {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	reader, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []Record{}
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "test", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := "<html><body>osu!\r\n\r\nclick the circles</body></html>"
+	if err = w.WriteExchange(testResponse(t, "https://osu.ppy.sh/home?a=1", body), []byte(body)); err != nil {
+		t.Fatal(err)
+	}
+
+	//the file isn't picked up until it is finished
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected no finished files got %v", files)
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err = Files(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || !strings.HasSuffix(files[0], ".warc.gz") {
+		t.Fatalf("expected one warc.gz file got %v", files)
+	}
+
+	records := readAll(t, files[0])
+	types := []string{}
+	for _, record := range records {
+		types = append(types, record.Type())
+	}
+	if strings.Join(types, ",") != "warcinfo,request,response" {
+		t.Fatalf("expected warcinfo,request,response got %v", types)
+	}
+
+	request := records[1]
+	if request.TargetURI() != "https://osu.ppy.sh/home?a=1" {
+		t.Errorf("expected target uri of the request got %v", request.TargetURI())
+	}
+	if !strings.HasPrefix(string(request.Block), "GET /home?a=1 HTTP/1.1\r\nHost: osu.ppy.sh\r\n") {
+		t.Errorf("unexpected request block %q", request.Block)
+	}
+
+	response := records[2]
+	if response.Header.Get("WARC-Concurrent-To") != "" || request.Header.Get("WARC-Concurrent-To") != response.Header.Get("WARC-Record-ID") {
+		t.Error("expected the request to point at the response")
+	}
+
+	resp, err := response.Response()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/html; charset=utf-8" || string(got) != body {
+		t.Errorf("response did not round trip: %d %v %q", resp.StatusCode, resp.Header, got)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	//every exchange goes past the limit
+	w, err := NewWriter(dir, "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, page := range []string{"https://osu.ppy.sh/a", "https://osu.ppy.sh/b", "https://osu.ppy.sh/c"} {
+		if err = w.WriteExchange(testResponse(t, page, page), []byte(page)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files got %v", files)
+	}
+
+	for i, page := range []string{"https://osu.ppy.sh/a", "https://osu.ppy.sh/b", "https://osu.ppy.sh/c"} {
+		records := readAll(t, files[i])
+		if len(records) != 3 || records[2].TargetURI() != page {
+			t.Errorf("%v: expected the exchange of %v", filepath.Base(files[i]), page)
+		}
+	}
+}
+
+func TestReadUncompressed(t *testing.T) {
+	record := "WARC/1.1\r\nWARC-Type: resource\r\nWARC-Target-URI: https://osu.ppy.sh/\r\nContent-Length: 5\r\n\r\nhello\r\n\r\n"
+
+	reader, err := NewReader(strings.NewReader(record + record))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		got, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Type() != "resource" || string(got.Block) != "hello" {
+			t.Errorf("unexpected record %v %q", got.Header, got.Block)
+		}
+	}
+	if _, err = reader.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF got %v", err)
+	}
+}
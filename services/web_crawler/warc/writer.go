@@ -0,0 +1,199 @@
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const version = "WARC/1.1"
+
+// suffix of a file that is still being written, replay skips them
+const openSuffix = ".open"
+
+// Writer appends request and response records to gzip compressed warc files
+// in a directory. Every record is its own gzip member so files can be read
+// from any record on. A file is closed and a new one started once it grew past
+// maxFileBytes.
+type Writer struct {
+	dir          string
+	prefix       string
+	maxFileBytes int64
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	serial int
+}
+
+// NewWriter writes files named <prefix>-<time>-<serial>.warc.gz to dir,
+// prefix has to be unique across crawler instances sharing dir
+func NewWriter(dir string, prefix string, maxFileBytes int64) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create warc directory %v %v", dir, err)
+	}
+	return &Writer{dir: dir, prefix: prefix, maxFileBytes: maxFileBytes}, nil
+}
+
+// WriteExchange writes the request that got resp and the response with body.
+// body is what was read from resp.Body, after any transfer and content
+// encoding the http client removed.
+func (w *Writer) WriteExchange(resp *http.Response, body []byte) error {
+	req := resp.Request
+	target := req.URL.String()
+	date := time.Now().UTC().Format(time.RFC3339)
+
+	var reqBlock bytes.Buffer
+	fmt.Fprintf(&reqBlock, "%v %v HTTP/1.1\r\nHost: %v\r\n", req.Method, req.URL.RequestURI(), req.URL.Host)
+	if err := req.Header.Write(&reqBlock); err != nil {
+		return err
+	}
+	reqBlock.WriteString("\r\n")
+
+	var respBlock bytes.Buffer
+	fmt.Fprintf(&respBlock, "%v %v\r\n", resp.Proto, resp.Status)
+	if err := resp.Header.Write(&respBlock); err != nil {
+		return err
+	}
+	respBlock.WriteString("\r\n")
+	respBlock.Write(body)
+
+	responseID := recordID()
+	response := []header{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", target},
+		{"WARC-Payload-Digest", digest(body)},
+		{"WARC-Block-Digest", digest(respBlock.Bytes())},
+		{"Content-Type", "application/http;msgtype=response"},
+	}
+	request := []header{
+		{"WARC-Type", "request"},
+		{"WARC-Record-ID", recordID()},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", target},
+		{"WARC-Concurrent-To", responseID},
+		{"WARC-Block-Digest", digest(reqBlock.Bytes())},
+		{"Content-Type", "application/http;msgtype=request"},
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	//both records go in the same file
+	if w.file == nil || w.size >= w.maxFileBytes {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	if err := w.writeRecord(request, reqBlock.Bytes()); err != nil {
+		return err
+	}
+	return w.writeRecord(response, respBlock.Bytes())
+}
+
+// Close finishes the current file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeFile()
+}
+
+type header struct {
+	name  string
+	value string
+}
+
+func (w *Writer) rotate() error {
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+
+	w.serial++
+	name := fmt.Sprintf("%v-%v-%05d.warc.gz", w.prefix, time.Now().UTC().Format("20060102150405"), w.serial)
+	file, err := os.OpenFile(filepath.Join(w.dir, name+openSuffix), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not create warc file %v %v", name, err)
+	}
+	w.file = file
+	w.size = 0
+
+	info := []byte("software: web_crawler\r\nformat: WARC File Format 1.1\r\n")
+	return w.writeRecord([]header{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", recordID()},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339)},
+		{"WARC-Filename", name},
+		{"Content-Type", "application/warc-fields"},
+	}, info)
+}
+
+// renames the finished file so replay picks it up
+func (w *Writer) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+
+	path := w.file.Name()
+	err := w.file.Close()
+	w.file = nil
+	if err != nil {
+		return fmt.Errorf("could not close warc file %v %v", path, err)
+	}
+
+	if err = os.Rename(path, path[:len(path)-len(openSuffix)]); err != nil {
+		return fmt.Errorf("could not rename warc file %v %v", path, err)
+	}
+	return nil
+}
+
+func (w *Writer) writeRecord(headers []header, block []byte) error {
+	var record bytes.Buffer
+	record.WriteString(version + "\r\n")
+	for _, h := range headers {
+		record.WriteString(h.name + ": " + h.value + "\r\n")
+	}
+	record.WriteString("Content-Length: " + strconv.Itoa(len(block)) + "\r\n\r\n")
+	record.Write(block)
+	record.WriteString("\r\n\r\n")
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(record.Bytes()); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	n, err := w.file.Write(compressed.Bytes())
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("could not write warc record to %v %v", w.file.Name(), err)
+	}
+	return nil
+}
+
+func recordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	//uuid version 4
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func digest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}